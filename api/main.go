@@ -3,8 +3,11 @@ package api
 import (
 	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"pathwae/proxy"
+	"strconv"
 )
 
 type CertInfo struct {
@@ -16,10 +19,16 @@ type CertInfo struct {
 	DNSNames   []string `json:"dnsNames"`
 }
 
+// BackendInfo is a backend with the state of its upstreams.
+type BackendInfo struct {
+	*proxy.Backend
+	Upstreams []*proxy.UpstreamState `json:"upstreams"`
+}
+
 // allow CORS
 func enableCORS(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
 	w.Header().Set("Access-Control-Expose-Headers", "Content-Length")
 }
@@ -27,7 +36,13 @@ func enableCORS(w http.ResponseWriter) {
 func GetBackends(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
 	// get the server list from poxy and return a json response
-	servers := proxy.GetBackends()
+	servers := make(map[string]*BackendInfo)
+	for name, backend := range proxy.GetBackends() {
+		servers[name] = &BackendInfo{
+			Backend:   backend,
+			Upstreams: proxy.GetUpstreams(name),
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(servers)
 }
@@ -92,7 +107,7 @@ func GetBackendState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	state := proxy.IsBackendUp(*server)
+	state := proxy.IsHealthy(serverName)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(state)
 }
@@ -103,7 +118,8 @@ func GetBackendStats(w http.ResponseWriter, r *http.Request) {
 	serverName := r.URL.Path[len("/api/v1/stats/"):]
 	server := proxy.GetBackend(serverName)
 
-	if server == nil {
+	// the requests refused for the unknown hosts have stats without server
+	if server == nil && serverName != proxy.UnknownHostsStats {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("No server found with name " + serverName))
 		return
@@ -114,6 +130,66 @@ func GetBackendStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
+func GetConfig(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	content, err := proxy.ExportYAMLConfig()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	w.Write([]byte(content))
+}
+
+func GetConfigHistory(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proxy.GetHistory())
+}
+
+func RollbackConfig(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST, OPTIONS")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	// revision is given in the path
+	revision, err := strconv.Atoi(r.URL.Path[len("/api/v1/config/rollback/"):])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid revision"))
+		return
+	}
+	log.Println("Received request to rollback to revision", revision)
+
+	changes, err := proxy.Rollback(revision)
+	if err == proxy.ErrRevisionNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+func GetRateLimit(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	state, err := proxy.GetRateLimit(r.URL.Path[len("/api/v1/ratelimit/"):])
+	if err != nil {
+		writeError(w, proxyErrorStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
 func GetRuntimeMemAlloc(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
 	w.Header().Set("Content-Type", "application/json")
@@ -126,56 +202,207 @@ func GetVersion(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(proxy.GetVersion())
 }
 
-func SetBackend(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
+// ErrorResponse is the JSON body sent when a request fails.
+type ErrorResponse struct {
+	Error   string               `json:"error"`
+	Details []*proxy.ConfigError `json:"details,omitempty"`
+}
 
-	if r.Method == "OPTIONS" {
-		return
+// writeError sends the error as a JSON body with the given status code.
+func writeError(w http.ResponseWriter, status int, err error) {
+	response := ErrorResponse{Error: err.Error()}
+	if errs, ok := err.(proxy.ConfigErrors); ok {
+		response.Error = "invalid backend"
+		response.Details = errs
 	}
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// proxyErrorStatus returns the HTTP status to use for an error returned by the proxy package.
+func proxyErrorStatus(err error) int {
+	switch err {
+	case proxy.ErrBackendNotFound, proxy.ErrNoRateLimit:
+		return http.StatusNotFound
+	case proxy.ErrBackendExists:
+		return http.StatusConflict
+	}
+	if _, ok := err.(proxy.ConfigErrors); ok {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// BackendHandler creates (POST), replaces (PUT), changes (PATCH), removes (DELETE) or returns (GET) the backend named in
+// the path.
+func BackendHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	switch r.Method {
+	case "OPTIONS":
 		return
+	case "GET":
+		GetBackend(w, r)
+	case "POST":
+		CreateBackend(w, r)
+	case "PUT":
+		SetBackend(w, r)
+	case "PATCH":
+		PatchBackend(w, r)
+	case "DELETE":
+		DeleteBackend(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
 	}
-	// name is given in the path
+}
+
+// backendName returns the backend name given in the path, an error is sent to the client if it's empty.
+func backendName(w http.ResponseWriter, r *http.Request) (string, bool) {
 	name := r.URL.Path[len("/api/v1/backend/"):]
-	log.Println("Received request to set backend: " + name)
 	if name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("No server name given"))
-		return
+		writeError(w, http.StatusBadRequest, errors.New("no server name given"))
+		return "", false
 	}
+	return name, true
+}
 
+// decodeBackend reads the backend from the request body, an error is sent to the client if it can't be decoded.
+func decodeBackend(w http.ResponseWriter, r *http.Request) (proxy.Backend, bool) {
 	// Get post data as JSON, this should be a proxy.Backend
 	var backend proxy.Backend
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&backend)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusBadRequest, err)
+		return backend, false
+	}
+	return backend, true
+}
+
+// writeBackend sends the backend as JSON with the given status code.
+func writeBackend(w http.ResponseWriter, status int, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&BackendInfo{
+		Backend:   proxy.GetBackend(name),
+		Upstreams: proxy.GetUpstreams(name),
+	})
+}
+
+func GetBackend(w http.ResponseWriter, r *http.Request) {
+	name, ok := backendName(w, r)
+	if !ok {
+		return
+	}
+	if proxy.GetBackend(name) == nil {
+		writeError(w, http.StatusNotFound, proxy.ErrBackendNotFound)
+		return
+	}
+	writeBackend(w, http.StatusOK, name)
+}
+
+func CreateBackend(w http.ResponseWriter, r *http.Request) {
+	name, ok := backendName(w, r)
+	if !ok {
 		return
 	}
-	err = proxy.SetBackend(name, backend)
+	log.Println("Received request to create backend: " + name)
+	backend, ok := decodeBackend(w, r)
+	if !ok {
+		return
+	}
+	if err := proxy.CreateBackend(name, backend); err != nil {
+		writeError(w, proxyErrorStatus(err), err)
+		return
+	}
+	w.Header().Set("Location", r.URL.Path)
+	writeBackend(w, http.StatusCreated, name)
+}
+
+func SetBackend(w http.ResponseWriter, r *http.Request) {
+	name, ok := backendName(w, r)
+	if !ok {
+		return
+	}
+	log.Println("Received request to set backend: " + name)
+	backend, ok := decodeBackend(w, r)
+	if !ok {
+		return
+	}
+	if err := proxy.SetBackend(name, backend); err != nil {
+		writeError(w, proxyErrorStatus(err), err)
+		return
+	}
+	writeBackend(w, http.StatusOK, name)
+}
+
+// PatchBackend changes only the fields given in the body, e.g. {"maintenance": {"enabled": true}}.
+func PatchBackend(w http.ResponseWriter, r *http.Request) {
+	name, ok := backendName(w, r)
+	if !ok {
+		return
+	}
+	log.Println("Received request to patch backend: " + name)
+	current := proxy.GetBackend(name)
+	if current == nil {
+		writeError(w, http.StatusNotFound, proxy.ErrBackendNotFound)
+		return
+	}
+
+	// the current backend is copied through JSON, the patch must not change it
+	var backend proxy.Backend
+	data, err := json.Marshal(current)
+	if err == nil {
+		err = json.Unmarshal(data, &backend)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&backend); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := proxy.SetBackend(name, backend); err != nil {
+		writeError(w, proxyErrorStatus(err), err)
+		return
+	}
+	writeBackend(w, http.StatusOK, name)
+}
+
+func DeleteBackend(w http.ResponseWriter, r *http.Request) {
+	name, ok := backendName(w, r)
+	if !ok {
+		return
+	}
+	log.Println("Received request to delete backend: " + name)
+	if err := proxy.DeleteBackend(name); err != nil {
+		writeError(w, proxyErrorStatus(err), err)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func Start(port string) error {
+func Start(ln net.Listener) error {
 	mux := http.NewServeMux()
 	// add the CORS middleware
 	mux.HandleFunc("/api/v1/servers", GetBackends)
 	mux.HandleFunc("/api/v1/cert/", GetCerts)
 	mux.HandleFunc("/api/v1/state/", GetBackendState)
 	mux.HandleFunc("/api/v1/stats/", GetBackendStats)
+	mux.HandleFunc("/api/v1/ratelimit/", GetRateLimit)
 	mux.HandleFunc("/api/v1/runtime/mem/alloc", GetRuntimeMemAlloc)
 	mux.HandleFunc("/api/v1/version", GetVersion)
+	mux.HandleFunc("/api/v1/config", GetConfig)
+	mux.HandleFunc("/api/v1/config/history", GetConfigHistory)
+	mux.HandleFunc("/api/v1/config/rollback/", RollbackConfig)
 	mux.HandleFunc("/api/v1/sse/status/", BackendSSE)
 	mux.HandleFunc("/api/v1/sse/global", GlobalSSE)
-	mux.HandleFunc("/api/v1/backend/", SetBackend)
+	mux.HandleFunc("/api/v1/backend/", BackendHandler)
 	mux.Handle("/", NewStaticHander("./web"))
 
-	log.Println("Starting server on address " + port)
-	return http.ListenAndServe(port, mux)
+	log.Println("Starting server on address " + ln.Addr().String())
+	return http.Serve(ln, mux)
 }
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pathwae/proxy"
+	"testing"
+)
+
+func TestGetRateLimit(t *testing.T) {
+	limited := proxy.Backend{
+		To:        proxy.Upstreams{{URL: "http://127.0.0.1:1"}},
+		RateLimit: &proxy.RateLimit{Rate: 0.001, Burst: 2},
+	}
+	if err := proxy.CreateBackend("limited.localhost", limited); err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.DeleteBackend("limited.localhost")
+	unlimited := proxy.Backend{To: proxy.Upstreams{{URL: "http://127.0.0.1:1"}}}
+	if err := proxy.CreateBackend("unlimited.localhost", unlimited); err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.DeleteBackend("unlimited.localhost")
+
+	// a request of the client takes a token
+	rec := httptest.NewRecorder()
+	(&proxy.ReverseProxy{}).ServeHTTP(rec, httptest.NewRequest("GET", "http://limited.localhost/", nil))
+	if remaining := rec.Header().Get("RateLimit-Remaining"); remaining != "1" {
+		t.Fatalf("got RateLimit-Remaining %q, want 1", remaining)
+	}
+
+	rec = httptest.NewRecorder()
+	GetRateLimit(rec, httptest.NewRequest("GET", "/api/v1/ratelimit/limited.localhost", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rec.Code)
+	}
+	var state proxy.RateLimitState
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Burst != 2 || state.Key != proxy.RateLimitByIP || len(state.Buckets) != 1 || state.Buckets[0].Key != "192.0.2.1" {
+		t.Errorf("got state %+v, want the bucket of 192.0.2.1", state)
+	}
+
+	for _, name := range []string{"unlimited.localhost", "unknown.localhost"} {
+		rec = httptest.NewRecorder()
+		GetRateLimit(rec, httptest.NewRequest("GET", "/api/v1/ratelimit/"+name, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want 404", name, rec.Code)
+		}
+	}
+}
@@ -9,7 +9,8 @@ import (
 )
 
 type ServerStatus struct {
-	Stat bool
+	Stat   bool
+	Health string // up, down or unknown
 }
 
 // BackendSSE is a server side event handler for a given Backend.
@@ -82,15 +83,24 @@ func BackendSSE(w http.ResponseWriter, r *http.Request) {
 
 		case <-time.Tick(1 * time.Second):
 			// send the server status
-			server = proxy.GetBackend(serverName)
-			serverStatus := proxy.IsBackendUp(*server)
-			status := ServerStatus{Stat: serverStatus}
+			if proxy.GetBackend(serverName) == nil {
+				log.Printf("SSE: Server %s removed", serverName)
+				return
+			}
+			health := proxy.Health(serverName)
+			status := ServerStatus{Stat: health != proxy.HealthDown, Health: health}
 			message, _ := json.Marshal(&status)
 
 			// the message type is "stats"
 			tosend := "event: status\n" +
 				"data: " + string(message) + "\n\n"
 			w.Write([]byte(tosend))
+
+			// and the state of the upstreams
+			upstreams, _ := json.Marshal(proxy.GetUpstreams(serverName))
+			tosend = "event: upstreams\n" +
+				"data: " + string(upstreams) + "\n\n"
+			w.Write([]byte(tosend))
 			// flush
 			w.(http.Flusher).Flush()
 		}
@@ -111,6 +121,9 @@ func GlobalSSE(w http.ResponseWriter, r *http.Request) {
 	changesListener := proxy.RegisterChangesListener()
 	defer proxy.UnregisterChangesListener(changesListener)
 
+	circuitListener := proxy.RegisterCircuitListener()
+	defer proxy.UnregisterCircuitListener(circuitListener)
+
 	for {
 		select {
 		case <-notify:
@@ -124,6 +137,13 @@ func GlobalSSE(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(tosend))
 			w.(http.Flusher).Flush()
 
+		case event := <-circuitListener:
+			eventJSON, _ := json.Marshal(event)
+			tosend := "event: circuit\n" +
+				"data: " + string(eventJSON) + "\n\n"
+			w.Write([]byte(tosend))
+			w.(http.Flusher).Flush()
+
 		case <-time.Tick(1 * time.Second):
 			// send the server status
 			memory := proxy.GetMemory()
@@ -9,6 +9,7 @@ import (
 	"pathwae/proxy"
 	"pathwae/service"
 	"runtime"
+	"time"
 )
 
 var (
@@ -16,7 +17,10 @@ var (
 	log     = stdlog.New(os.Stdout, "[CMD] ", stdlog.Lmsgprefix|stdlog.LstdFlags)
 )
 
-const globalConfFile = "/global/config.yaml"
+const (
+	globalConfFile       = "/global/config.yaml"
+	defaultWatchInterval = 2 * time.Second
+)
 
 func main() {
 	// set the max proc to the number of cpus
@@ -30,6 +34,15 @@ func main() {
 	}
 	proxy.Version = Version
 
+	// "pathwae validate <file>" checks the configuration and exits
+	if flag.Arg(0) == "validate" {
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: pathwae validate <file>")
+			os.Exit(2)
+		}
+		os.Exit(validate(flag.Arg(1)))
+	}
+
 	// can be empty, not a problem
 	confToLoad := os.Getenv("CONFIG_FILE")
 
@@ -72,7 +85,45 @@ func main() {
 		log.Println("You didn't provide a config file in CONFIG_FILE or CONFIG env variable, this means that no backends will be loaded")
 	}
 
-	// get conf from CONF envuronment
-	service.Start(conf)
+	// reload the configuration when the file changes or on SIGHUP
+	watchInterval := defaultWatchInterval
+	if len(os.Getenv("CONFIG_WATCH_INTERVAL")) > 0 {
+		watchInterval, err = time.ParseDuration(os.Getenv("CONFIG_WATCH_INTERVAL"))
+		if err != nil {
+			log.Fatalf("Invalid CONFIG_WATCH_INTERVAL: %v", err)
+		}
+	}
+
+	// write the API changes in the configuration file if asked
+	if os.Getenv("CONFIG_PERSIST") == "1" || os.Getenv("CONFIG_PERSIST") == "true" {
+		proxy.EnablePersistence(confToLoad)
+	}
 
+	// get conf from CONF envuronment, the file is watched once it is loaded
+	service.Load(conf)
+	go proxy.WatchConfigFile(confToLoad, watchInterval)
+	service.Start()
+
+}
+
+// validate prints the problems found in the configuration file and returns the exit code to use.
+func validate(file string) int {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	errs := proxy.ValidateConfig(string(content))
+	for _, e := range errs {
+		// print the errors like a compiler does: "file:line: message"
+		msg := *e
+		msg.Line = 0
+		fmt.Printf("%s:%d: %s\n", file, e.Line, &msg)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Println(file + ": configuration is valid")
+	return 0
 }
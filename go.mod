@@ -2,4 +2,7 @@ module pathwae
 
 go 1.16
 
-require gopkg.in/yaml.v2 v2.4.0
+require (
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AccessList restricts the clients by address. A denied address is always refused, and if Allow is not empty, the
+// address must be in it. Addresses are IPs or CIDRs, like "192.168.0.0/16" or "::1".
+type AccessList struct {
+	// Allow are the only addresses accepted, all if empty.
+	Allow []string `yaml:"allow,omitempty" json:"allow,omitempty"`
+
+	// Deny are the refused addresses.
+	Deny []string `yaml:"deny,omitempty" json:"deny,omitempty"`
+
+	// parsed Allow and Deny
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// validate checks and parses the addresses.
+func (a *AccessList) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	var err error
+	if a.allow, err = parseNetworks(a.Allow); err != nil {
+		errs = append(errs, &ConfigError{Field: "allow", Message: err.Error()})
+	}
+	if a.deny, err = parseNetworks(a.Deny); err != nil {
+		errs = append(errs, &ConfigError{Field: "deny", Message: err.Error()})
+	}
+	return errs
+}
+
+// allows returns true if the address is accepted.
+func (a *AccessList) allows(ip net.IP) bool {
+	if containsIP(a.deny, ip) {
+		return false
+	}
+	return len(a.allow) == 0 || containsIP(a.allow, ip)
+}
+
+// parseNetworks parses a list of IPs or CIDRs, an IP is a network of one address.
+func parseNetworks(addresses []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(addresses))
+	for _, address := range addresses {
+		if !strings.Contains(address, "/") {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: address}
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP returns true if the address is in one of the networks.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowed returns true if the client of the request is accepted by the global and the backend access lists. The backend
+// can be nil.
+func isAllowed(req *http.Request, backend *Backend) bool {
+	ip := net.ParseIP(clientIP(req))
+	if !currentSettings().AccessList.allows(ip) {
+		return false
+	}
+	return backend == nil || backend.AccessList.allows(ip)
+}
+
+// clientIP returns the address of the client, without the port. If the request comes from a trusted proxy, the address
+// is the last one of X-Forwarded-For that is not a trusted proxy.
+func clientIP(req *http.Request) string {
+	remote := remoteIP(req)
+	if !fromTrustedProxy(req) {
+		return remote
+	}
+
+	trusted := currentSettings().trustedProxies
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		ip := net.ParseIP(address)
+		if ip == nil {
+			break
+		}
+		if !containsIP(trusted, ip) {
+			return address
+		}
+	}
+	return remote
+}
+
+// fromTrustedProxy returns true if the request is sent by a trusted proxy, its X-Forwarded headers can be kept.
+func fromTrustedProxy(req *http.Request) bool {
+	return containsIP(currentSettings().trustedProxies, net.ParseIP(remoteIP(req)))
+}
+
+// remoteIP returns the address of the connection, without the port.
+func remoteIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBlockedUnknownHostsStats(t *testing.T) {
+	defer settings.Store(currentSettings())
+	defer table.Store(currentTable())
+	s := &Settings{AccessList: AccessList{Deny: []string{"192.0.2.0/24"}}}
+	if errs := s.validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	settings.Store(s)
+	table.Store(newRoutingTable(nil))
+
+	before := len(GetStat(UnknownHostsStats))
+	hosts := []string{"a.unknown.localhost", "b.unknown.localhost:8001", "c.unknown.localhost"}
+	for _, host := range hosts {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		(&ReverseProxy{}).ServeHTTP(rw, req)
+		if rw.Code != http.StatusForbidden {
+			t.Fatalf("%s: status %d, want 403", host, rw.Code)
+		}
+	}
+
+	// the stats are recorded in background
+	deadline := time.Now().Add(time.Second)
+	for len(GetStat(UnknownHostsStats)) < before+len(hosts) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := len(GetStat(UnknownHostsStats)); count != before+len(hosts) {
+		t.Errorf("%d blocked requests counted for the unknown hosts, want %d", count-before, len(hosts))
+	}
+	for _, host := range hosts {
+		if stats := GetStat(hostName(host)); len(stats) > 0 {
+			t.Errorf("%s: stats recorded under the requested host", host)
+		}
+	}
+}
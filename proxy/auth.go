@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// defaultUserHeader is the header where the authenticated user is sent to the upstream.
+	defaultUserHeader = "X-Forwarded-User"
+
+	// maxVerifiedCredentials is the size of the cache of the checked passwords, bcrypt is slow on purpose.
+	maxVerifiedCredentials = 1024
+)
+
+// BasicAuth protects a backend with the HTTP Basic authentication. Passwords are bcrypt hashes (htpasswd -B).
+type BasicAuth struct {
+	// Realm is shown by the browsers in the login dialog.
+	Realm string `yaml:"realm,omitempty" json:"realm,omitempty"`
+
+	// Users are the user names and their password hashes.
+	Users map[string]string `yaml:"users,omitempty" json:"users,omitempty"`
+
+	// Htpasswd is a file of users, read again when it changes.
+	Htpasswd string `yaml:"htpasswd,omitempty" json:"htpasswd,omitempty"`
+
+	// UserHeader is the header where the user is sent to the upstream, "X-Forwarded-User" by default, "-" to not send it.
+	UserHeader string `yaml:"user_header,omitempty" json:"user_header,omitempty"`
+
+	// Exclude are the path prefixes that don't need an authentication, e.g. a health check.
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+}
+
+// htpasswdFile is a loaded htpasswd file, loaded again when it changes.
+type htpasswdFile struct {
+	modTime time.Time
+	users   map[string]string
+}
+
+var (
+	htpasswdFiles     = make(map[string]*htpasswdFile)
+	htpasswdFilesLock sync.Mutex
+
+	// verifiedCredentials are the sha256 of the valid "hash:user:password", to not run bcrypt on each request.
+	verifiedCredentials     = make(map[[sha256.Size]byte]bool)
+	verifiedCredentialsLock sync.Mutex
+)
+
+// validate checks the users and the htpasswd file.
+func (a *BasicAuth) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if a == nil {
+		return errs
+	}
+	if len(a.Users) == 0 && a.Htpasswd == "" {
+		errs = append(errs, &ConfigError{Field: "basic_auth", Message: "no users and no htpasswd file"})
+	}
+	for user, hash := range a.Users {
+		if user == "" || strings.Contains(user, ":") {
+			errs = append(errs, &ConfigError{Field: "basic_auth.users", Message: "invalid user name " + user})
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			errs = append(errs, &ConfigError{Field: "basic_auth.users", Message: "password of " + user + " is not a bcrypt hash"})
+		}
+	}
+	if a.UserHeader != "" && a.UserHeader != "-" && !validHeaderName(a.UserHeader) {
+		errs = append(errs, &ConfigError{Field: "basic_auth.user_header", Message: "invalid header name " + a.UserHeader})
+	}
+	for _, path := range a.Exclude {
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, &ConfigError{Field: "basic_auth.exclude", Message: "path must start with /"})
+		}
+	}
+	return errs
+}
+
+// authenticate checks the credentials of the request. The authenticated user replaces the user header of the request,
+// that can't be given by the client. The path is cleaned before checking the exclusions, and sent cleaned to the upstream.
+// It returns false if the access is refused.
+func (a *BasicAuth) authenticate(req *http.Request) bool {
+	header := a.UserHeader
+	if header == "" {
+		header = defaultUserHeader
+	}
+	if header != "-" {
+		req.Header.Del(header)
+	}
+	if len(a.Exclude) > 0 {
+		// "/health/../admin" is "/admin" for the upstream, it must not match the "/health" exclusion
+		if cleaned := cleanPath(req.URL.Path); cleaned != req.URL.Path {
+			req.URL.Path, req.URL.RawPath = cleaned, ""
+		}
+		for _, prefix := range a.Exclude {
+			if hasPathPrefix(req.URL.Path, prefix) {
+				return true
+			}
+		}
+	}
+
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, ok := a.Users[user]
+	if !ok && a.Htpasswd != "" {
+		users, err := loadHtpasswd(a.Htpasswd)
+		if err != nil {
+			log.Println("Failed to read", a.Htpasswd, err)
+		}
+		hash, ok = users[user]
+	}
+	if !ok || !checkPassword(hash, user, password) {
+		log.Printf("Authentication failed for %q on %s", user, req.Host)
+		return false
+	}
+	if header != "-" {
+		req.Header.Set(header, user)
+	}
+	return true
+}
+
+// cleanPath returns the path without the dot segments and the repeated slashes, the trailing slash is kept.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// challenge asks the client to authenticate.
+func (a *BasicAuth) challenge(rw http.ResponseWriter) {
+	realm := a.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	rw.Header().Set("WWW-Authenticate", `Basic realm="`+strings.Replace(realm, `"`, `'`, -1)+`", charset="UTF-8"`)
+}
+
+// checkPassword compares the password to the bcrypt hash, the valid passwords are cached.
+func checkPassword(hash, user, password string) bool {
+	key := sha256.Sum256([]byte(hash + ":" + user + ":" + password))
+	verifiedCredentialsLock.Lock()
+	verified := verifiedCredentials[key]
+	verifiedCredentialsLock.Unlock()
+	if verified {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	verifiedCredentialsLock.Lock()
+	if len(verifiedCredentials) >= maxVerifiedCredentials {
+		verifiedCredentials = make(map[[sha256.Size]byte]bool)
+	}
+	verifiedCredentials[key] = true
+	verifiedCredentialsLock.Unlock()
+	return true
+}
+
+// loadHtpasswd returns the users of the htpasswd file, it is read again if the file changed. Only the bcrypt hashes are
+// accepted.
+func loadHtpasswd(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	htpasswdFilesLock.Lock()
+	defer htpasswdFilesLock.Unlock()
+	if cached, ok := htpasswdFiles[path]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.users, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+			log.Printf("Ignoring user %q of %s, only bcrypt passwords are supported (htpasswd -B)", parts[0], path)
+			continue
+		}
+		users[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded %d users from %s", len(users), path)
+	htpasswdFiles[path] = &htpasswdFile{modTime: info.ModTime(), users: users}
+	return users, nil
+}
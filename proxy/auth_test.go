@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuthExclude(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &BasicAuth{
+		Users:   map[string]string{"admin": string(hash)},
+		Exclude: []string{"/health", "/public/"},
+	}
+
+	tests := []struct {
+		target   string
+		password string
+		allowed  bool
+		path     string
+	}{
+		{target: "/health", allowed: true, path: "/health"},
+		{target: "/health/live", allowed: true, path: "/health/live"},
+		{target: "/public/", allowed: true, path: "/public/"},
+		{target: "/healthz", allowed: false, path: "/healthz"},
+		{target: "/admin", allowed: false, path: "/admin"},
+		{target: "/admin", password: "secret", allowed: true, path: "/admin"},
+		{target: "/admin", password: "wrong", allowed: false, path: "/admin"},
+		{target: "/health/../admin", allowed: false, path: "/admin"},
+		{target: "/health/%2e%2e/admin", allowed: false, path: "/admin"},
+		{target: "/health/%2E%2E/admin/", allowed: false, path: "/admin/"},
+		{target: "/public/../../health", allowed: true, path: "/health"},
+		{target: "/health/./live", allowed: true, path: "/health/live"},
+		{target: "//health", allowed: true, path: "/health"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "http://app.localhost"+test.target, nil)
+		if test.password != "" {
+			req.SetBasicAuth("admin", test.password)
+		}
+		if allowed := auth.authenticate(req); allowed != test.allowed {
+			t.Errorf("%s (password %q): allowed %v, want %v", test.target, test.password, allowed, test.allowed)
+		}
+		if req.URL.Path != test.path {
+			t.Errorf("%s: forwarded path %q, want %q", test.target, req.URL.Path, test.path)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load balancing strategies.
+const (
+	BalanceRoundRobin = "round_robin"
+	BalanceRandom     = "random"
+	BalanceLeastConn  = "least_conn"
+	BalanceWeighted   = "weighted"
+)
+
+var (
+	// upstreamStates keeps the state of the upstreams, by backend and url, so it survives to configuration changes. The
+	// upstreams resolved for the hosts of wildcard and regex backends are not kept here, the clients choose the hosts.
+	upstreamStates = make(map[string]*upstreamState)
+	upstreamLock   sync.Mutex
+)
+
+// Upstream is one of the urls where the requests of a backend can be sent.
+type Upstream struct {
+	// URL is the address of the upstream.
+	URL string `yaml:"url" json:"url"`
+
+	// Weight is the weight of the upstream for the "weighted" strategy, default is 1.
+	Weight int `yaml:"weight,omitempty" json:"weight,omitempty"`
+}
+
+// UnmarshalYAML accepts a simple url string or an object with url and weight.
+func (u *Upstream) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var url string
+	if err := unmarshal(&url); err == nil {
+		u.URL = url
+		return nil
+	}
+	type upstream Upstream // avoid recursion
+	return unmarshal((*upstream)(u))
+}
+
+// MarshalYAML writes a simple url string if the weight is not set.
+func (u *Upstream) MarshalYAML() (interface{}, error) {
+	if u.Weight == 0 {
+		return u.URL, nil
+	}
+	type upstream Upstream
+	return (*upstream)(u), nil
+}
+
+// UnmarshalJSON accepts a simple url string or an object with url and weight.
+func (u *Upstream) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		u.URL = url
+		return nil
+	}
+	type upstream Upstream
+	return json.Unmarshal(data, (*upstream)(u))
+}
+
+// MarshalJSON writes a simple url string if the weight is not set.
+func (u *Upstream) MarshalJSON() ([]byte, error) {
+	if u.Weight == 0 {
+		return json.Marshal(u.URL)
+	}
+	type upstream Upstream
+	return json.Marshal((*upstream)(u))
+}
+
+// Upstreams is the list of urls of a backend or a route. In configuration, it can be a simple url or a list of urls.
+type Upstreams []*Upstream
+
+// UnmarshalYAML accepts one upstream or a list of upstreams.
+func (ups *Upstreams) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []*Upstream
+	if err := unmarshal(&list); err == nil {
+		*ups = list
+		return nil
+	}
+	one := &Upstream{}
+	if err := unmarshal(one); err != nil {
+		return err
+	}
+	*ups = Upstreams{one}
+	return nil
+}
+
+// MarshalYAML writes a single upstream if there is only one.
+func (ups Upstreams) MarshalYAML() (interface{}, error) {
+	if len(ups) == 1 {
+		return ups[0].MarshalYAML()
+	}
+	return []*Upstream(ups), nil
+}
+
+// UnmarshalJSON accepts one upstream or a list of upstreams.
+func (ups *Upstreams) UnmarshalJSON(data []byte) error {
+	var list []*Upstream
+	if err := json.Unmarshal(data, &list); err == nil {
+		*ups = list
+		return nil
+	}
+	one := &Upstream{}
+	if err := json.Unmarshal(data, one); err != nil {
+		return err
+	}
+	*ups = Upstreams{one}
+	return nil
+}
+
+// MarshalJSON writes a single upstream if there is only one.
+func (ups Upstreams) MarshalJSON() ([]byte, error) {
+	if len(ups) == 1 {
+		return json.Marshal(ups[0])
+	}
+	return json.Marshal([]*Upstream(ups))
+}
+
+// String returns the urls separated by a comma.
+func (ups Upstreams) String() string {
+	urls := make([]string, len(ups))
+	for i, u := range ups {
+		urls[i] = u.URL
+	}
+	return strings.Join(urls, ", ")
+}
+
+// UpstreamState is the runtime state of an upstream.
+type UpstreamState struct {
+	URL      string `json:"url"`
+	Route    string `json:"route,omitempty"`
+	Weight   int    `json:"weight"`
+	Active   int64  `json:"active"`
+	Requests int64  `json:"requests"`
+
+	// Healthy is false if the upstream failed its health checks.
+	Healthy bool `json:"healthy"`
+
+	// Health is "up", "down", or "unknown" for the upstreams that are not checked.
+	Health string `json:"health"`
+
+	// HealthError is the error of the last health check.
+	HealthError string `json:"health_error,omitempty"`
+
+	// Circuit is the state of the circuit breaker, if the backend has one.
+	Circuit string `json:"circuit,omitempty"`
+
+	// Backup is true for the upstreams used only when the others are unavailable.
+	Backup bool `json:"backup,omitempty"`
+}
+
+// upstreamState counts the requests of an upstream.
+type upstreamState struct {
+	active   int64
+	requests int64
+
+	// health check results: healthy is 1 if the upstream can get requests, checked is 1 once it is checked
+	healthy     int32
+	checked     int32
+	healthError atomic.Value
+
+	// circuit is the state of the circuit breaker
+	circuit circuit
+}
+
+// acquire counts a new request on the upstream.
+func (s *upstreamState) acquire() {
+	atomic.AddInt64(&s.active, 1)
+	atomic.AddInt64(&s.requests, 1)
+}
+
+// release must be called when the request is done.
+func (s *upstreamState) release() {
+	atomic.AddInt64(&s.active, -1)
+}
+
+// getUpstreamState returns the state of the url for the backend, it is created if needed.
+func getUpstreamState(backend, url string) *upstreamState {
+	upstreamLock.Lock()
+	defer upstreamLock.Unlock()
+	key := backend + " " + url
+	state, ok := upstreamStates[key]
+	if !ok {
+		state = &upstreamState{healthy: 1}
+		upstreamStates[key] = state
+	}
+	return state
+}
+
+// pruneUpstreamStates removes the states of the upstreams that are not used by the backends anymore. The upstreams of the
+// history revisions are kept, the backends restored by a rollback still use their states.
+func pruneUpstreamStates(backends map[string]*Backend) {
+	used := make(map[string]bool)
+	use := func(backends map[string]*Backend) {
+		for name, b := range backends {
+			for _, u := range b.upstreams() {
+				used[name+" "+u.URL] = true
+			}
+		}
+	}
+	use(backends)
+	for _, rev := range GetHistory() {
+		use(rev.backends)
+	}
+
+	upstreamLock.Lock()
+	defer upstreamLock.Unlock()
+	for key := range upstreamStates {
+		if !used[key] {
+			delete(upstreamStates, key)
+		}
+	}
+}
+
+// localUpstreamStates returns a function creating the states of the upstreams of a resolved backend. They are kept with
+// the backend in the cache of the router, and released with it.
+func localUpstreamStates() func(url string) *upstreamState {
+	states := make(map[string]*upstreamState)
+	return func(url string) *upstreamState {
+		state, ok := states[url]
+		if !ok {
+			state = &upstreamState{healthy: 1}
+			states[url] = state
+		}
+		return state
+	}
+}
+
+// pool chooses the upstream to use for each request.
+type pool struct {
+	counter   uint64 // first field to be aligned for atomic operations
+	strategy  string
+	upstreams []*poolUpstream
+	lock      sync.Mutex
+
+	// backend is the name of the backend, breaker is its circuit breaker, nil if it has none
+	backend string
+	breaker *CircuitBreaker
+
+	// backup is the pool used when no upstream is available, isBackup is true for the backup pool itself
+	backup   *pool
+	isBackup bool
+}
+
+// poolUpstream is an upstream with its runtime data.
+type poolUpstream struct {
+	*Upstream
+	state   *upstreamState
+	weight  int
+	current int // smooth weighted round robin value
+}
+
+// newPool creates the pool for the upstreams of the given backend, with their states.
+func newPool(backend, strategy string, upstreams Upstreams, state func(url string) *upstreamState) *pool {
+	p := &pool{backend: backend, strategy: strategy}
+	for _, u := range upstreams {
+		weight := u.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		p.upstreams = append(p.upstreams, &poolUpstream{
+			Upstream: u,
+			state:    state(u.URL),
+			weight:   weight,
+		})
+	}
+	return p
+}
+
+// next returns the upstream to use for the next request, nil if there is no upstream or if all their circuits are open.
+// The unhealthy upstreams and the open circuits are skipped, the backup upstreams are used if no other upstream is
+// available. If they are all unhealthy, the requests are sent anyway.
+func (p *pool) next() *poolUpstream {
+	now := time.Now()
+	if u := p.available(now); u != nil {
+		return u
+	}
+	if p == nil {
+		return nil
+	}
+	if u := p.backup.available(now); u != nil {
+		return u
+	}
+	for i := 0; i < len(p.upstreams); i++ {
+		if u := p.pick(); p.allows(u, now) {
+			return u
+		}
+	}
+	return nil
+}
+
+// available returns a healthy upstream whose circuit is closed, nil if there is none.
+func (p *pool) available(now time.Time) *poolUpstream {
+	if p == nil || len(p.upstreams) == 0 {
+		return nil
+	}
+	usable := func(u *poolUpstream) bool {
+		return u.state.isHealthy() && p.allows(u, now)
+	}
+	for i := 0; i < len(p.upstreams); i++ {
+		if u := p.pick(); usable(u) {
+			return u
+		}
+	}
+	for _, u := range p.upstreams {
+		if usable(u) {
+			return u
+		}
+	}
+	return nil
+}
+
+// isEmpty returns true if the pool has no upstream.
+func (p *pool) isEmpty() bool {
+	return p == nil || len(p.upstreams) == 0
+}
+
+// pick returns the next upstream of the balancing strategy.
+func (p *pool) pick() *poolUpstream {
+	if p == nil || len(p.upstreams) == 0 {
+		return nil
+	}
+	if len(p.upstreams) == 1 {
+		return p.upstreams[0]
+	}
+
+	switch p.strategy {
+	case BalanceRandom:
+		return p.upstreams[rand.Intn(len(p.upstreams))]
+
+	case BalanceLeastConn:
+		// start from a rotating offset, so equal upstreams are used in turn
+		offset := int(atomic.AddUint64(&p.counter, 1))
+		var chosen *poolUpstream
+		for i := range p.upstreams {
+			u := p.upstreams[(offset+i)%len(p.upstreams)]
+			if chosen == nil || atomic.LoadInt64(&u.state.active) < atomic.LoadInt64(&chosen.state.active) {
+				chosen = u
+			}
+		}
+		return chosen
+
+	case BalanceWeighted:
+		// smooth weighted round robin, as nginx does
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		total := 0
+		var chosen *poolUpstream
+		for _, u := range p.upstreams {
+			u.current += u.weight
+			total += u.weight
+			if chosen == nil || u.current > chosen.current {
+				chosen = u
+			}
+		}
+		chosen.current -= total
+		return chosen
+
+	default:
+		n := atomic.AddUint64(&p.counter, 1) - 1
+		return p.upstreams[n%uint64(len(p.upstreams))]
+	}
+}
+
+// states returns the state of the upstreams of the pool.
+func (p *pool) states(route string) []*UpstreamState {
+	states := make([]*UpstreamState, 0)
+	if p == nil {
+		return states
+	}
+	for _, u := range p.upstreams {
+		states = append(states, &UpstreamState{
+			URL:      u.URL,
+			Route:    route,
+			Weight:   u.weight,
+			Active:   atomic.LoadInt64(&u.state.active),
+			Requests: atomic.LoadInt64(&u.state.requests),
+			Healthy:  u.state.isHealthy(),
+			Health:   u.state.health(),
+			Backup:   p.isBackup,
+		})
+		if p.breaker != nil {
+			states[len(states)-1].Circuit = u.state.circuit.current()
+		}
+		if err, ok := u.state.healthError.Load().(string); ok {
+			states[len(states)-1].HealthError = err
+		}
+	}
+	return states
+}
+
+// validateBalance checks the load balancing strategy.
+func validateBalance(strategy string) error {
+	switch strategy {
+	case "", BalanceRoundRobin, BalanceRandom, BalanceLeastConn, BalanceWeighted:
+		return nil
+	default:
+		return errors.New("unknown balance strategy " + strategy)
+	}
+}
+
+// prepare creates the runtime data of the backend, it must be called once the backend is validated. The upstreams keep
+// their state of the previous configurations.
+func (b *Backend) prepare(name string) {
+	b.preparePools(name, func(url string) *upstreamState {
+		return getUpstreamState(name, url)
+	})
+}
+
+// preparePools creates the pools of the backend and its routes, with the given upstream states.
+func (b *Backend) preparePools(name string, state func(url string) *upstreamState) {
+	backup := newPool(name, b.Balance, b.Backup, state)
+	backup.isBackup, backup.breaker = true, b.CircuitBreaker
+	b.pool = newPool(name, b.Balance, b.To, state)
+	b.pool.breaker, b.pool.backup = b.CircuitBreaker, backup
+	for _, route := range b.Routes {
+		route.pool = newPool(name, b.Balance, route.To, state)
+		route.pool.breaker, route.pool.backup = b.CircuitBreaker, backup
+	}
+}
+
+// GetUpstreams returns the state of all the upstreams of the backend, sorted by route and url.
+func GetUpstreams(backendName string) []*UpstreamState {
+	b := GetBackend(backendName)
+	if b == nil {
+		return nil
+	}
+	backends := []*Backend{b}
+	if b.hasPlaceholders() {
+		// the upstreams are the ones resolved for the requested hosts
+		backends = currentTable().router.resolvedBackends(backendName)
+	}
+
+	states := make([]*UpstreamState, 0)
+	for _, b := range backends {
+		states = append(states, b.pool.states("")...)
+		states = append(states, b.pool.backup.states("")...)
+		for _, route := range b.Routes {
+			states = append(states, route.pool.states(route.Path)...)
+		}
+	}
+	sort.SliceStable(states, func(i, j int) bool {
+		if states[i].Route != states[j].Route {
+			return states[i].Route < states[j].Route
+		}
+		return states[i].URL < states[j].URL
+	})
+	return states
+}
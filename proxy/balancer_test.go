@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPoolStrategies(t *testing.T) {
+	upstreams := Upstreams{{URL: "http://a", Weight: 3}, {URL: "http://b"}, {URL: "http://c", Weight: -1}}
+
+	tests := []struct {
+		strategy string
+		busy     []int // upstreams with an active request
+		picks    string
+	}{
+		{strategy: BalanceRoundRobin, picks: "abcabcabc"},
+		{strategy: "", picks: "abcabc"},
+		{strategy: BalanceWeighted, picks: "abacaabaca"},
+		{strategy: BalanceLeastConn, busy: []int{0, 1}, picks: "cccc"},
+		// the upstreams with as many requests are used in turn, from a rotating offset
+		{strategy: BalanceLeastConn, busy: []int{1}, picks: "ccacca"},
+	}
+	for _, test := range tests {
+		p := newPool("app.localhost", test.strategy, upstreams, localUpstreamStates())
+		for _, i := range test.busy {
+			p.upstreams[i].state.acquire()
+		}
+		picks := ""
+		for range test.picks {
+			picks += strings.TrimPrefix(p.pick().URL, "http://")
+		}
+		if picks != test.picks {
+			t.Errorf("%q with busy %v: picked %s, want %s", test.strategy, test.busy, picks, test.picks)
+		}
+	}
+
+	p := newPool("app.localhost", BalanceRandom, upstreams, localUpstreamStates())
+	for i := 0; i < 20; i++ {
+		if u := p.pick(); u == nil || !strings.HasPrefix(u.URL, "http://") {
+			t.Fatalf("random: picked %v", u)
+		}
+	}
+}
+
+func TestPoolSkipsUnhealthy(t *testing.T) {
+	p := newPool("app.localhost", BalanceRoundRobin, Upstreams{{URL: "http://a"}, {URL: "http://b"}}, localUpstreamStates())
+	p.upstreams[0].state.setHealthy(false)
+	for i := 0; i < 4; i++ {
+		if u := p.next(); u.URL != "http://b" {
+			t.Fatalf("got %s, the unhealthy upstream must be skipped", u.URL)
+		}
+	}
+
+	// all unhealthy, the requests are sent anyway
+	p.upstreams[1].state.setHealthy(false)
+	if u := p.next(); u == nil {
+		t.Fatal("no upstream while they are all unhealthy")
+	}
+
+	if u := newPool("app.localhost", "", nil, localUpstreamStates()).next(); u != nil {
+		t.Errorf("got %s from an empty pool", u.URL)
+	}
+}
+
+// loadTestConfig replaces the backends by the ones of the configuration.
+func loadTestConfig(t *testing.T, content string) {
+	conf, err := parseYAMLConfig(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceBackends(OriginReload, conf.Backends)
+}
+
+func TestUpstreamStatesPruned(t *testing.T) {
+	defer resetHistory()()
+	defer replaceBackends(OriginReload, currentTable().backends)
+
+	const kept = `a.localhost:
+  to: http://127.0.0.1:1
+`
+	loadTestConfig(t, `a.localhost:
+  to: [http://127.0.0.1:1, http://127.0.0.1:2]
+b.localhost:
+  to: http://127.0.0.1:3
+`)
+	loadTestConfig(t, kept)
+
+	states := func() map[string]bool {
+		upstreamLock.Lock()
+		defer upstreamLock.Unlock()
+		keys := make(map[string]bool)
+		for key := range upstreamStates {
+			keys[key] = true
+		}
+		return keys
+	}
+	// the previous revision can be restored with its states
+	for _, key := range []string{"a.localhost http://127.0.0.1:1", "a.localhost http://127.0.0.1:2", "b.localhost http://127.0.0.1:3"} {
+		if !states()[key] {
+			t.Errorf("the state of %s is removed while it is in the history", key)
+		}
+	}
+
+	// once out of the history, the states of the removed upstreams are removed
+	for i := 0; i < maxHistory; i++ {
+		if i%2 == 0 {
+			loadTestConfig(t, kept+"c.localhost:\n  to: http://127.0.0.1:4\n")
+		} else {
+			loadTestConfig(t, kept)
+		}
+	}
+	keys := states()
+	if !keys["a.localhost http://127.0.0.1:1"] {
+		t.Error("the state of a used upstream is removed")
+	}
+	for _, key := range []string{"a.localhost http://127.0.0.1:2", "b.localhost http://127.0.0.1:3"} {
+		if keys[key] {
+			t.Errorf("the state of %s is kept", key)
+		}
+	}
+}
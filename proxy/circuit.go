@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Circuit states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// Circuit breaker defaults.
+const (
+	defaultCircuitFailures = 5
+	defaultCircuitCooldown = 30 * time.Second
+)
+
+// CircuitBreaker stops sending requests to an upstream that keeps failing. After Failures consecutive errors or 5xx
+// responses the circuit is open: the upstream gets no request during the cooldown. Then one request is sent to try it
+// (half open), the circuit is closed again if it succeeds.
+type CircuitBreaker struct {
+	// Failures is the number of consecutive failures to open the circuit, 5 by default.
+	Failures int `yaml:"failures,omitempty" json:"failures,omitempty"`
+
+	// Cooldown is the time to wait before trying again an upstream, 30s by default.
+	Cooldown Duration `yaml:"cooldown,omitempty" json:"cooldown,omitempty"`
+}
+
+// CircuitEvent is sent when the circuit of an upstream changes.
+type CircuitEvent struct {
+	Backend string    `json:"backend"`
+	URL     string    `json:"url"`
+	State   string    `json:"state"`
+	Time    time.Time `json:"time"`
+}
+
+// circuit is the breaker state of an upstream.
+type circuit struct {
+	state    string
+	failures int
+	openedAt time.Time
+	trying   bool // a request is trying the half open circuit
+	lock     sync.Mutex
+}
+
+// upstreamContextKey is the context key of the upstream chosen for a request.
+type upstreamContextKey struct{}
+
+var (
+	circuitListeners = make([]chan *CircuitEvent, 0)
+	circuitLock      sync.Mutex
+)
+
+// validate checks the circuit breaker settings.
+func (c *CircuitBreaker) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if c != nil && c.Failures < 0 {
+		errs = append(errs, &ConfigError{Field: "circuit_breaker.failures", Message: "failures can't be negative"})
+	}
+	return errs
+}
+
+// allows returns true if a request can be sent to the upstream. It opens the half open circuit to one request, and
+// returns true as second value when the circuit becomes half open. Without breaker, the requests are always allowed.
+func (c *circuit) allows(breaker *CircuitBreaker, now time.Time) (bool, bool) {
+	if breaker == nil {
+		return true, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch c.state {
+	case CircuitOpen:
+		if now.Sub(c.openedAt) < breaker.Cooldown.or(defaultCircuitCooldown) {
+			return false, false
+		}
+		c.state, c.trying = CircuitHalfOpen, true
+		return true, true
+	case CircuitHalfOpen:
+		if c.trying {
+			return false, false
+		}
+		c.trying = true
+		return true, false
+	default:
+		return true, false
+	}
+}
+
+// report records the result of a request, and returns the new state of the circuit if it changed.
+func (c *circuit) report(breaker *CircuitBreaker, failed bool, now time.Time) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	threshold := breaker.Failures
+	if threshold == 0 {
+		threshold = defaultCircuitFailures
+	}
+
+	previous := c.state
+	switch {
+	case !failed:
+		c.state, c.failures = CircuitClosed, 0
+	case c.state == CircuitHalfOpen:
+		c.state, c.openedAt = CircuitOpen, now
+	default:
+		c.failures++
+		if c.failures >= threshold && c.state != CircuitOpen {
+			c.state, c.openedAt = CircuitOpen, now
+		}
+	}
+	c.trying = false
+	if c.state == previous || (previous == "" && c.state == CircuitClosed) {
+		return ""
+	}
+	return c.state
+}
+
+// abort ends the request trying the half open circuit without result, another request can try it.
+func (c *circuit) abort() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.trying = false
+}
+
+// current returns the state of the circuit.
+func (c *circuit) current() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state == "" {
+		return CircuitClosed
+	}
+	return c.state
+}
+
+// circuitTransport reports the result of each request to the circuit of the upstream it is sent to.
+type circuitTransport struct {
+	backend   string
+	breaker   *CircuitBreaker
+	transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *circuitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	upstream, ok := req.Context().Value(upstreamContextKey{}).(*poolUpstream)
+	if !ok {
+		return resp, err
+	}
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		// the client left, the upstream is not the culprit
+		upstream.state.circuit.abort()
+		return resp, err
+	}
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	if state := upstream.state.circuit.report(t.breaker, failed, time.Now()); state != "" {
+		circuitChanged(t.backend, upstream.URL, state)
+	}
+	return resp, err
+}
+
+// allows returns true if the circuit of the upstream lets a request go.
+func (p *pool) allows(u *poolUpstream, now time.Time) bool {
+	allowed, halfOpen := u.state.circuit.allows(p.breaker, now)
+	if halfOpen {
+		circuitChanged(p.backend, u.URL, CircuitHalfOpen)
+	}
+	return allowed
+}
+
+// circuitChanged logs and notifies the new state of the circuit of an upstream.
+func circuitChanged(backend, url, state string) {
+	log.Printf("Circuit of %s for %s is %s", url, backend, state)
+	notifyCircuit(&CircuitEvent{Backend: backend, URL: url, State: state, Time: time.Now()})
+}
+
+// withUpstream returns the request with the upstream it is sent to, for the circuit breaker.
+func withUpstream(req *http.Request, upstream *poolUpstream) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), upstreamContextKey{}, upstream))
+}
+
+// RegisterCircuitListener registers a channel to receive the circuit changes of all the backends.
+func RegisterCircuitListener() chan *CircuitEvent {
+	circuitLock.Lock()
+	defer circuitLock.Unlock()
+	listener := make(chan *CircuitEvent, 10)
+	circuitListeners = append(circuitListeners, listener)
+	return listener
+}
+
+// UnregisterCircuitListener unregisters a channel from receiving the circuit changes.
+func UnregisterCircuitListener(listener chan *CircuitEvent) {
+	circuitLock.Lock()
+	defer circuitLock.Unlock()
+	close(listener)
+	for i, l := range circuitListeners {
+		if l == listener {
+			circuitListeners = append(circuitListeners[:i], circuitListeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// notifyCircuit sends the event to the listeners, the slow listeners miss it.
+func notifyCircuit(event *CircuitEvent) {
+	circuitLock.Lock()
+	defer circuitLock.Unlock()
+	for _, listener := range circuitListeners {
+		select {
+		case listener <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuit(t *testing.T) {
+	breaker := &CircuitBreaker{Failures: 2, Cooldown: Duration(10 * time.Second)}
+	start := time.Now()
+
+	// steps are run in order on the same circuit
+	steps := []struct {
+		name     string
+		at       time.Duration
+		report   string // "fail", "success" or "abort", else the circuit is asked
+		allowed  bool
+		halfOpen bool
+		changed  string
+		state    string
+	}{
+		{name: "new circuit", allowed: true, state: CircuitClosed},
+		{name: "first failure", report: "fail", state: CircuitClosed},
+		{name: "success resets", report: "success", state: CircuitClosed},
+		{name: "failure again", report: "fail", state: CircuitClosed},
+		{name: "threshold", report: "fail", changed: CircuitOpen, state: CircuitOpen},
+		{name: "cooldown", at: 9 * time.Second, allowed: false, state: CircuitOpen},
+		{name: "after cooldown", at: 10 * time.Second, allowed: true, halfOpen: true, state: CircuitHalfOpen},
+		{name: "one request tries", at: 10 * time.Second, allowed: false, state: CircuitHalfOpen},
+		{name: "client left", at: 10 * time.Second, report: "abort", state: CircuitHalfOpen},
+		{name: "another request tries", at: 11 * time.Second, allowed: true, state: CircuitHalfOpen},
+		{name: "try failed", at: 11 * time.Second, report: "fail", changed: CircuitOpen, state: CircuitOpen},
+		{name: "cooldown again", at: 20 * time.Second, allowed: false, state: CircuitOpen},
+		{name: "after new cooldown", at: 21 * time.Second, allowed: true, halfOpen: true, state: CircuitHalfOpen},
+		{name: "try succeeded", at: 21 * time.Second, report: "success", changed: CircuitClosed, state: CircuitClosed},
+		{name: "closed", at: 21 * time.Second, allowed: true, state: CircuitClosed},
+	}
+	c := &circuit{}
+	for _, step := range steps {
+		now := start.Add(step.at)
+		switch step.report {
+		case "fail", "success":
+			if changed := c.report(breaker, step.report == "fail", now); changed != step.changed {
+				t.Errorf("%s: changed to %q, want %q", step.name, changed, step.changed)
+			}
+		case "abort":
+			c.abort()
+		default:
+			allowed, halfOpen := c.allows(breaker, now)
+			if allowed != step.allowed || halfOpen != step.halfOpen {
+				t.Errorf("%s: allowed %v half open %v, want %v %v", step.name, allowed, halfOpen, step.allowed, step.halfOpen)
+			}
+		}
+		if state := c.current(); state != step.state {
+			t.Errorf("%s: state %s, want %s", step.name, state, step.state)
+		}
+	}
+}
+
+func TestCircuitDefaults(t *testing.T) {
+	c := &circuit{}
+	if allowed, _ := c.allows(nil, time.Now()); !allowed {
+		t.Error("request refused without breaker")
+	}
+
+	start := time.Now()
+	for i := 1; i <= defaultCircuitFailures; i++ {
+		changed := c.report(&CircuitBreaker{}, true, start)
+		if (i == defaultCircuitFailures) != (changed == CircuitOpen) {
+			t.Errorf("failure %d: changed to %q", i, changed)
+		}
+	}
+	if allowed, _ := c.allows(&CircuitBreaker{}, start.Add(defaultCircuitCooldown-time.Second)); allowed {
+		t.Error("request allowed during the default cooldown")
+	}
+	if allowed, _ := c.allows(&CircuitBreaker{}, start.Add(defaultCircuitCooldown)); !allowed {
+		t.Error("request refused after the default cooldown")
+	}
+}
+
+func TestPoolCircuits(t *testing.T) {
+	b := &Backend{
+		To:             Upstreams{{URL: "http://a"}, {URL: "http://b"}},
+		Backup:         Upstreams{{URL: "http://backup"}},
+		CircuitBreaker: &CircuitBreaker{Failures: 1},
+	}
+	b.preparePools("app.localhost", localUpstreamStates())
+	open := func(u *poolUpstream) {
+		u.state.circuit.report(b.CircuitBreaker, true, time.Now())
+	}
+
+	open(b.pool.upstreams[0])
+	for i := 0; i < 4; i++ {
+		if u := b.pool.next(); u.URL != "http://b" {
+			t.Fatalf("got %s, the open circuit must be skipped", u.URL)
+		}
+	}
+	open(b.pool.upstreams[1])
+	if u := b.pool.next(); u == nil || u.URL != "http://backup" {
+		t.Fatalf("got %v, the backup must be used when all the circuits are open", u)
+	}
+	open(b.pool.backup.upstreams[0])
+	if u := b.pool.next(); u != nil {
+		t.Errorf("got %s while all the circuits are open", u.URL)
+	}
+}
+
+func TestCircuitTrialWithoutRoundTrip(t *testing.T) {
+	breaker := &CircuitBreaker{Failures: 1}
+	openedLongAgo := func(p *pool) *circuit {
+		c := &p.upstreams[0].state.circuit
+		c.report(breaker, true, time.Now().Add(-time.Hour))
+		return c
+	}
+
+	// a retry to a file upstream is not sent
+	upstreams := newPool("app.localhost", "", Upstreams{{URL: "file:///srv/site"}}, localUpstreamStates())
+	upstreams.breaker = breaker
+	c := openedLongAgo(upstreams)
+	transport := &retryTransport{retry: &Retry{}, upstreams: upstreams}
+	if _, err := transport.next(httptest.NewRequest("GET", "http://a/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := c.allows(breaker, time.Now()); !allowed {
+		t.Error("retry: the half open circuit stays reserved by a request that is not sent")
+	}
+
+	// a file upstream is served without round trip
+	defer table.Store(currentTable())
+	enabled := true
+	b := &Backend{To: Upstreams{{URL: "file://" + t.TempDir()}}, CircuitBreaker: breaker, Enabled: &enabled}
+	b.prepare("static.localhost")
+	table.Store(newRoutingTable(map[string]*Backend{"static.localhost": b}))
+	c = openedLongAgo(b.pool)
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		(&ReverseProxy{}).ServeHTTP(rw, httptest.NewRequest("GET", "http://static.localhost/", nil))
+		if rw.Code == http.StatusServiceUnavailable {
+			t.Fatalf("static request %d refused by the circuit", i+1)
+		}
+	}
+	if c.current() != CircuitHalfOpen {
+		t.Errorf("circuit is %s, want half_open", c.current())
+	}
+}
@@ -3,83 +3,330 @@ package proxy
 import (
 	"errors"
 	"reflect"
+	"sort"
 	"sync"
 
 	"gopkg.in/yaml.v2"
 )
 
+// Change actions.
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change is sent to the changes listeners when a backend is created, updated or deleted.
 type Change struct {
 	Name    string  `json:"name"`
+	Action  string  `json:"action"`
 	Backend Backend `json:"backend"`
 }
 
 var (
-	backendList map[string]*Backend
-	changeLock  sync.Mutex
+	// ErrBackendNotFound is returned when changing a backend that is not configured.
+	ErrBackendNotFound = errors.New("backend not found")
+
+	// ErrBackendExists is returned when creating a backend that is already configured.
+	ErrBackendExists = errors.New("backend already exists")
 )
 
+var changeLock sync.Mutex
+
 // Backend is a proxy configured service from conf.
 type Backend struct {
-	// To is the url where to send the request.
-	To string `yaml:"to" json:"to"`
+	// To is the url where to send the request, or a list of urls to balance the requests. A "file://" url serves the files
+	// of a directory.
+	To Upstreams `yaml:"to" json:"to"`
+
+	// Backup are the urls used when no upstream of To (or of the route) is available.
+	Backup Upstreams `yaml:"backup,omitempty" json:"backup,omitempty"`
+
+	// Balance is the load balancing strategy when To has several urls: "round_robin" (default), "random", "least_conn"
+	// or "weighted".
+	Balance string `yaml:"balance,omitempty" json:"balance,omitempty"`
 
-	// ForceSSL forces the connection to be ssl. If true, any HTTP connection will be redirected to HTTPS.
+	// Static are the options of the file upstreams, used when To is a directory (file:///path/to/dir).
+	Static *Static `yaml:"static,omitempty" json:"static,omitempty"`
+
+	// Routes are the path based rules to send some requests to other urls. If no route matches, To is used.
+	Routes []*Route `yaml:"routes,omitempty" json:"routes,omitempty"`
+
+	// Redirects are the redirections sent to the client instead of proxying, the first matching one is used. A backend can
+	// have only redirections.
+	Redirects []*Redirect `yaml:"redirects,omitempty" json:"redirects,omitempty"`
+
+	// PathRewrite changes the path sent to the upstreams, the routes can have their own rules.
+	PathRewrite `yaml:",inline"`
+
+	// ForceSSL forces the connection to be ssl. If true, any HTTP connection will be redirected to HTTPS, on the https_port
+	// of the settings or the port of the HTTPS entrypoint.
 	ForceSSL bool `yaml:"force_ssl" json:"force_ssl"`
 
 	// Enabled is the flag to enable or disable the service.
 	Enabled *bool `yaml:"enabled,omitempty" json:"enabled,omitempty" default:"true"`
+
+	// Maintenance answers a maintenance page instead of proxying, now or during scheduled windows.
+	Maintenance *Maintenance `yaml:"maintenance,omitempty" json:"maintenance,omitempty"`
+
+	// Entrypoints are the names of the entrypoints serving this backend, all if empty.
+	Entrypoints []string `yaml:"entrypoints,omitempty" json:"entrypoints,omitempty"`
+
+	// AccessList restricts the clients by address, after the global restrictions.
+	AccessList `yaml:",inline"`
+
+	// RateLimit limits the number of requests, by client or for all the clients.
+	RateLimit *RateLimit `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+
+	// Timeouts are the timeouts of the requests sent to the upstreams.
+	Timeouts *Timeouts `yaml:"timeouts,omitempty" json:"timeouts,omitempty"`
+
+	// HealthCheck checks the upstreams in background, the unhealthy ones are skipped.
+	HealthCheck *HealthCheck `yaml:"health_check,omitempty" json:"health_check,omitempty"`
+
+	// CircuitBreaker stops sending requests to the upstreams that keep failing.
+	CircuitBreaker *CircuitBreaker `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+
+	// Retry sends again the requests that failed.
+	Retry *Retry `yaml:"retry,omitempty" json:"retry,omitempty"`
+
+	// BasicAuth asks the clients for a user and a password.
+	BasicAuth *BasicAuth `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"`
+
+	// RequestHeaders are the changes of the headers sent to the upstream.
+	RequestHeaders *HeaderRules `yaml:"request_headers,omitempty" json:"request_headers,omitempty"`
+
+	// ResponseHeaders are the changes of the headers returned by the upstream.
+	ResponseHeaders *HeaderRules `yaml:"response_headers,omitempty" json:"response_headers,omitempty"`
+
+	// SecurityHeaders adds the security headers, like HSTS or CSP, to the responses.
+	SecurityHeaders *SecurityHeaders `yaml:"security_headers,omitempty" json:"security_headers,omitempty"`
+
+	// ErrorPages are the templates to use for the errors of this backend, they win over the global ones.
+	ErrorPages ErrorPages `yaml:"error_pages,omitempty" json:"error_pages,omitempty"`
+
+	// upstreams selection of To
+	pool *pool
+
+	// declaration order, used to sort the regex hosts
+	order int
 }
 
-// LoadServers loads the servers from the conf file.
-func LoadYAMLConfig(content string) map[string]*Backend {
-	var servers map[string]*Backend
+// ParseYAMLConfig reads the servers from the conf content. Nothing is applied, so it can be used to check a configuration.
+// The returned error is a ConfigErrors if the configuration is not valid.
+func ParseYAMLConfig(content string) (map[string]*Backend, error) {
+	conf, err := parseYAMLConfig(content)
+	if err != nil {
+		return nil, err
+	}
+	return conf.Backends, nil
+}
+
+// parseYAMLConfig reads the settings and the servers from the conf content, the servers are ready to be used.
+func parseYAMLConfig(content string) (*configFile, error) {
+	conf, errs := parseConfig(content)
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
-	err := yaml.Unmarshal([]byte(content), &servers)
+	for from, to := range conf.Backends {
+		to.setDefaults()
+		to.prepare(from)
+	}
+	return conf, nil
+}
+
+// setDefaults sets the default values of the fields that are not set.
+func (b *Backend) setDefaults() {
+	// default is to enable the backend
+	if b.Enabled == nil {
+		b.Enabled = new(bool)
+		// get the default value from the tags
+		tag, _ := reflect.TypeOf(b).Elem().FieldByName("Enabled")
+		if tag.Tag.Get("default") != "" {
+			*b.Enabled = tag.Tag.Get("default") == "true"
+		} else {
+			*b.Enabled = true
+		}
+	}
+}
+
+// LoadServers loads the servers from the conf file.
+func LoadYAMLConfig(content string) (map[string]*Backend, error) {
+	conf, err := parseYAMLConfig(content)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	servers := conf.Backends
 
 	// give information about the servers
 	for from, to := range servers {
-		// default is to enable the backend
-		if to.Enabled == nil {
-			to.Enabled = new(bool)
-			// get the default value from the tags
-			tag, _ := reflect.TypeOf(to).Elem().FieldByName("Enabled")
-			if tag.Tag.Get("default") != "" {
-				*to.Enabled = tag.Tag.Get("default") == "true"
-			} else {
-				*to.Enabled = true
-			}
+		log.Printf("Configured %s -> %s", from, to.To)
+		for _, route := range to.Routes {
+			log.Printf("Configured %s%s -> %s", from, route.Path, route.To)
 		}
+	}
 
-		log.Printf("Configured %s -> %s", from, to.To)
+	settings.Store(&conf.Settings)
+	setSource(content, servers)
+	replaceBackends(OriginFile, servers)
+	return servers, nil
+}
+
+// ReloadConfig replaces the running settings and backends by the ones of the conf content. Only the differences of the
+// backends are applied and sent to the changes listeners. If the configuration is not valid, the running configuration is
+// kept and the error is returned. The listeners and the server timeouts are read at startup, a configuration that
+// changes them is refused.
+func ReloadConfig(content string) error {
+	conf, err := parseYAMLConfig(content)
+	if err != nil {
+		return err
+	}
+	if keys := conf.Settings.startupChanges(currentSettings()); len(keys) > 0 {
+		lines := newConfigLines(content)
+		errs := make(ConfigErrors, 0, len(keys))
+		for _, key := range keys {
+			errs = append(errs, &ConfigError{
+				Host:    key,
+				Line:    lines.host(key),
+				Message: "can't change while running, restart Pathwae to apply it",
+			})
+		}
+		return errs
+	}
+
+	settings.Store(&conf.Settings)
+	setSource(content, conf.Backends)
+	for _, change := range replaceBackends(OriginReload, conf.Backends) {
+		log.Printf("Reload: %s %s", change.Action, change.Name)
+	}
+	return nil
+}
+
+// replaceBackends replaces all the backends of the routing table and returns the changes.
+func replaceBackends(origin string, servers map[string]*Backend) []*Change {
+	var changes []*Change
+	updateTable(origin, func(backends map[string]*Backend) ([]*Change, error) {
+		changes = diffBackends(backends, servers)
+		for name := range backends {
+			delete(backends, name)
+		}
+		for name, b := range servers {
+			backends[name] = b
+		}
+		return changes, nil
+	})
+	return changes
+}
+
+// CreateBackend adds a backend for the given host. It returns ErrBackendExists if the host is already configured, or a
+// ConfigErrors if the backend is not valid.
+func CreateBackend(name string, b Backend) error {
+	log.Println("Create backend:", name, b)
+	if errs := b.validateWith(name, currentSettings()); len(errs) > 0 {
+		return errs
 	}
+	b.setDefaults()
+	b.prepare(name)
 
-	backendList = servers
-	return servers
+	return updateTable(OriginAPI, func(backends map[string]*Backend) ([]*Change, error) {
+		if _, ok := backends[name]; ok {
+			return nil, ErrBackendExists
+		}
+		for _, other := range backends {
+			if other.order >= b.order {
+				b.order = other.order + 1
+			}
+		}
+		backends[name] = &b
+		return []*Change{{Name: name, Action: ChangeCreate, Backend: b}}, nil
+	})
 }
 
-// SetBackend sets the backend for the given host.
+// SetBackend replaces the backend for the given host. It returns ErrBackendNotFound if the host is not configured, or a
+// ConfigErrors if the backend is not valid.
 func SetBackend(name string, b Backend) error {
 	log.Println("Change backend:", name, b)
-	if b.To == "" {
-		return errors.New("Backend url is empty")
+	if errs := b.validateWith(name, currentSettings()); len(errs) > 0 {
+		return errs
 	}
-	if _, ok := backendList[name]; !ok {
-		return errors.New("Backend url doesn't exists in the config")
+	b.setDefaults()
+	b.prepare(name)
+
+	return updateTable(OriginAPI, func(backends map[string]*Backend) ([]*Change, error) {
+		old, ok := backends[name]
+		if !ok {
+			return nil, ErrBackendNotFound
+		}
+		b.order = old.order
+		backends[name] = &b
+		return []*Change{{Name: name, Action: ChangeUpdate, Backend: b}}, nil
+	})
+}
+
+// DeleteBackend removes the backend of the given host. It returns ErrBackendNotFound if the host is not configured.
+func DeleteBackend(name string) error {
+	log.Println("Delete backend:", name)
+
+	return updateTable(OriginAPI, func(backends map[string]*Backend) ([]*Change, error) {
+		old, ok := backends[name]
+		if !ok {
+			return nil, ErrBackendNotFound
+		}
+		delete(backends, name)
+		return []*Change{{Name: name, Action: ChangeDelete, Backend: *old}}, nil
+	})
+}
+
+// diffBackends returns the changes to apply to go from the "from" backends to the "to" backends.
+func diffBackends(from, to map[string]*Backend) []*Change {
+	changes := make([]*Change, 0)
+	for name, b := range to {
+		old, ok := from[name]
+		switch {
+		case !ok:
+			changes = append(changes, &Change{Name: name, Action: ChangeCreate, Backend: *b})
+		case !old.equal(b):
+			changes = append(changes, &Change{Name: name, Action: ChangeUpdate, Backend: *b})
+		}
+	}
+	for name, b := range from {
+		if _, ok := to[name]; !ok {
+			changes = append(changes, &Change{Name: name, Action: ChangeDelete, Backend: *b})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// equal returns true if the two backends have the same configuration.
+func (b *Backend) equal(other *Backend) bool {
+	left, err := yaml.Marshal(b)
+	if err != nil {
+		return false
 	}
-	backendList[name] = &b
+	right, err := yaml.Marshal(other)
+	if err != nil {
+		return false
+	}
+	return string(left) == string(right)
+}
 
-	go func() {
-		changeLock.Lock()
-		defer changeLock.Unlock()
+// notifyChanges sends the changes to all the changes listeners. It is called under the table lock, so the listeners get
+// the changes in the order of the table updates. The listeners that are too slow miss the changes, they can't block the
+// table updates.
+func notifyChanges(changes ...*Change) {
+	changeLock.Lock()
+	defer changeLock.Unlock()
+	for _, change := range changes {
 		for _, listener := range changesListeners {
-			listener <- &Change{
-				Name:    name,
-				Backend: b,
+			select {
+			case listener <- change:
+			default:
+				log.Printf("Changes listener is too slow, %s of %s is not sent", change.Action, change.Name)
 			}
 		}
-	}()
-	return nil
+	}
 }
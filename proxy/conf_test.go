@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadConfigListeners(t *testing.T) {
+	defer settings.Store(currentSettings())
+	settings.Store(&Settings{Listeners: Listeners{HTTP: ":8001"}})
+
+	err := ReloadConfig(`listeners:
+  http: ":8001"
+  entrypoints:
+    - name: admin
+      address: ":9000"
+
+app.localhost:
+  to: http://app:8080
+  entrypoints: [admin]
+`)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 1 || errs[0].Host != "listeners" || errs[0].Line != 1 {
+		t.Fatalf("reload with new listeners: got %v, want an error on the listeners", err)
+	}
+	if !strings.Contains(errs[0].Message, "restart") {
+		t.Errorf("the error doesn't tell to restart: %s", errs[0].Message)
+	}
+	if listeners := GetListeners(); len(listeners.Entrypoints) != 0 {
+		t.Errorf("the listeners changed to %v", listeners)
+	}
+}
+
+func TestReloadConfigServerTimeouts(t *testing.T) {
+	defer settings.Store(currentSettings())
+	settings.Store(&Settings{})
+
+	err := ReloadConfig(`app.localhost:
+  to: http://app:8080
+
+server_timeouts:
+  read: 10s
+`)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 1 || errs[0].Host != "server_timeouts" || errs[0].Line != 4 {
+		t.Fatalf("reload with new server timeouts: got %v, want an error on the server timeouts", err)
+	}
+	if timeouts := currentSettings().ServerTimeouts; timeouts != (ServerTimeouts{}) {
+		t.Errorf("the server timeouts changed to %v", timeouts)
+	}
+}
+
+func TestChangesOrder(t *testing.T) {
+	defer table.Store(currentTable())
+	listener := RegisterChangesListener()
+	defer UnregisterChangesListener(listener)
+
+	toggle := func(backends map[string]*Backend) ([]*Change, error) {
+		if _, ok := backends["order.localhost"]; ok {
+			delete(backends, "order.localhost")
+			return []*Change{{Name: "order.localhost", Action: ChangeDelete}}, nil
+		}
+		b := &Backend{To: Upstreams{{URL: "http://app"}}}
+		backends["order.localhost"] = b
+		return []*Change{{Name: "order.localhost", Action: ChangeCreate, Backend: *b}}, nil
+	}
+	const updates = 50
+	for i := 0; i < updates; i++ {
+		if err := updateTable(OriginReload, toggle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < updates; i++ {
+		want := ChangeCreate
+		if i%2 == 1 {
+			want = ChangeDelete
+		}
+		if change := <-listener; change.Action != want {
+			t.Fatalf("change %d is %s, want %s", i, change.Action, want)
+		}
+	}
+}
+
+func TestChangesSlowListener(t *testing.T) {
+	slow := RegisterChangesListener()
+	listener := RegisterChangesListener()
+	defer UnregisterChangesListener(listener)
+
+	// nobody reads the slow listener, it must not block the others nor its unregistration
+	for i := 0; i < cap(slow)+10; i++ {
+		notifyChanges(&Change{Name: "slow.localhost", Action: ChangeUpdate})
+		<-listener
+	}
+	done := make(chan struct{})
+	go func() {
+		UnregisterChangesListener(slow)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("unregistering a slow listener is blocked")
+	}
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Names of the default entrypoints.
+const (
+	EntrypointHTTP  = "http"
+	EntrypointHTTPS = "https"
+)
+
+// Listeners are the addresses where Pathwae listens. They are read at startup, a restart is needed to change them: the
+// reloaded configurations must keep them.
+type Listeners struct {
+	// HTTP is the address of the HTTP entrypoint, ":80" by default, "off" to disable it.
+	HTTP string `yaml:"http,omitempty" json:"http,omitempty"`
+
+	// HTTPS is the address of the HTTPS entrypoint, ":443" by default, "off" to disable it.
+	HTTPS string `yaml:"https,omitempty" json:"https,omitempty"`
+
+	// API is the address of the API and the UI, ":8080" by default, "off" to disable it.
+	API string `yaml:"api,omitempty" json:"api,omitempty"`
+
+	// Entrypoints are the other addresses where the backends are served.
+	Entrypoints []*Entrypoint `yaml:"entrypoints,omitempty" json:"entrypoints,omitempty"`
+}
+
+// Entrypoint is an additional address to serve the backends.
+type Entrypoint struct {
+	// Name is used to restrict the backends to some entrypoints.
+	Name string `yaml:"name" json:"name"`
+
+	// Address is the address to listen, like ":8000", "127.0.0.1:8000" or "[::1]:8000".
+	Address string `yaml:"address" json:"address"`
+
+	// TLS serves HTTPS on this entrypoint.
+	TLS bool `yaml:"tls,omitempty" json:"tls,omitempty"`
+}
+
+// listeningEntrypoint is a started entrypoint.
+type listeningEntrypoint struct {
+	name string
+	port int
+	tls  bool
+}
+
+var (
+	// listening are the started entrypoints by name, their ports are used in the links and the redirections.
+	listening     = make(map[string]*listeningEntrypoint)
+	listeningLock sync.Mutex
+)
+
+// validate checks the addresses and the names of the entrypoints.
+func (l *Listeners) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	check := func(field, address string) {
+		if address == "" || address == "off" {
+			return
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			errs = append(errs, &ConfigError{Field: field, Message: err.Error()})
+		}
+	}
+	check("http", l.HTTP)
+	check("https", l.HTTPS)
+	check("api", l.API)
+
+	names := []string{EntrypointHTTP, EntrypointHTTPS}
+	for i, e := range l.Entrypoints {
+		field := fmt.Sprintf("entrypoints[%d]", i)
+		if e == nil || e.Address == "" {
+			errs = append(errs, &ConfigError{Field: field, Message: "entrypoint address is empty"})
+			continue
+		}
+		check(field+".address", e.Address)
+		if e.Name == "" || contains(names, e.Name) {
+			errs = append(errs, &ConfigError{Field: field + ".name", Message: "entrypoint name is empty or already used"})
+		}
+		names = append(names, e.Name)
+	}
+	return errs
+}
+
+// checkEntrypoints returns an error for each entrypoint name that is not declared.
+func (l *Listeners) checkEntrypoints(names []string) ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	declared := []string{EntrypointHTTP, EntrypointHTTPS}
+	for _, e := range l.Entrypoints {
+		if e != nil {
+			declared = append(declared, e.Name)
+		}
+	}
+	for _, name := range names {
+		if !contains(declared, name) {
+			errs = append(errs, &ConfigError{Field: "entrypoints", Message: "unknown entrypoint " + name})
+		}
+	}
+	return errs
+}
+
+// servesEntrypoint returns true if the backend is served on the named entrypoint.
+func (b *Backend) servesEntrypoint(name string) bool {
+	return len(b.Entrypoints) == 0 || contains(b.Entrypoints, name)
+}
+
+// GetListeners returns the listeners of the loaded configuration.
+func GetListeners() Listeners {
+	return currentSettings().Listeners
+}
+
+// SetEntrypointAddress records the address where the entrypoint listens. The links to the backends use its port, and
+// force_ssl redirects to the port of the HTTPS entrypoint if https_port is not configured.
+func SetEntrypointAddress(name, address string, useTLS bool) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return
+	}
+	listeningLock.Lock()
+	defer listeningLock.Unlock()
+	listening[name] = &listeningEntrypoint{name: name, port: p, tls: useTLS}
+}
+
+// listeningEntrypoints returns the started entrypoints, the default ones first and the others by name.
+func listeningEntrypoints() []*listeningEntrypoint {
+	listeningLock.Lock()
+	defer listeningLock.Unlock()
+	entrypoints := make([]*listeningEntrypoint, 0, len(listening))
+	for _, e := range listening {
+		entrypoints = append(entrypoints, e)
+	}
+	rank := func(name string) int {
+		switch name {
+		case EntrypointHTTP:
+			return 0
+		case EntrypointHTTPS:
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(entrypoints, func(i, j int) bool {
+		if rank(entrypoints[i].name) != rank(entrypoints[j].name) {
+			return rank(entrypoints[i].name) < rank(entrypoints[j].name)
+		}
+		return entrypoints[i].name < entrypoints[j].name
+	})
+	return entrypoints
+}
+
+// httpsPort returns the HTTPS port to use in the redirections, 0 if it is unknown.
+func httpsPort() int {
+	if port := currentSettings().HTTPSPort; port != 0 {
+		return port
+	}
+	listeningLock.Lock()
+	defer listeningLock.Unlock()
+	if e, ok := listening[EntrypointHTTPS]; ok {
+		return e.port
+	}
+	return 0
+}
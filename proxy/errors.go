@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorPagesDir is the directory of the error page templates, relative paths in error_pages are taken from there.
+const errorPagesDir = "/errors"
+
+// ErrorPages are the templates to use for the errors. Keys are a status code ("404"), a class ("5xx") or a range
+// ("500-504"), values are html/template files. The most specific key wins.
+type ErrorPages map[string]string
+
+// ErrorPage is the data given to the error page templates, and the body of the JSON errors.
+type ErrorPage struct {
+	Host      string    `json:"host"`
+	Status    int       `json:"status"`
+	Error     string    `json:"error"`
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+}
+
+// StatusText returns the text of the status code, e.g. "Not Found".
+func (p *ErrorPage) StatusText() string {
+	return http.StatusText(p.Status)
+}
+
+// errorTemplate is a parsed template file, parsed again when the file changes.
+type errorTemplate struct {
+	modTime  time.Time
+	template *template.Template
+}
+
+var (
+	errorTemplates     = make(map[string]*errorTemplate)
+	errorTemplatesLock sync.Mutex
+)
+
+// validate checks the keys and the files of the error pages.
+func (pages ErrorPages) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	for key, file := range pages {
+		if _, _, err := parseStatusRange(key); err != nil {
+			errs = append(errs, &ConfigError{Field: "error_pages." + key, Message: err.Error()})
+		}
+		if file == "" {
+			errs = append(errs, &ConfigError{Field: "error_pages." + key, Message: "template file is empty"})
+		}
+	}
+	return errs
+}
+
+// lookup returns the template file for the status code, or an empty string.
+func (pages ErrorPages) lookup(status int) string {
+	file, size := "", 0
+	for key, f := range pages {
+		from, to, err := parseStatusRange(key)
+		if err != nil || status < from || status > to {
+			continue
+		}
+		if file == "" || to-from < size {
+			file, size = f, to-from
+		}
+	}
+	return file
+}
+
+// parseStatusRange returns the first and last status codes of an error pages key.
+func parseStatusRange(key string) (int, int, error) {
+	from, to := key, key
+	switch {
+	case len(key) == 3 && strings.HasSuffix(strings.ToLower(key), "xx"):
+		from, to = key[:1]+"00", key[:1]+"99"
+	case strings.Contains(key, "-"):
+		parts := strings.SplitN(key, "-", 2)
+		from, to = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	}
+	first, err1 := strconv.Atoi(from)
+	last, err2 := strconv.Atoi(to)
+	if err1 != nil || err2 != nil || first < 100 || last > 599 || first > last {
+		return 0, 0, fmt.Errorf("invalid status %q, use a code (404), a class (5xx) or a range (500-504)", key)
+	}
+	return first, last, nil
+}
+
+// errorPageFile returns the template file to use for the backend and the status, the backend pages win over the global
+// ones. It returns an empty string if there is no template.
+func errorPageFile(backend *Backend, status int) string {
+	file := ""
+	if backend != nil {
+		file = backend.ErrorPages.lookup(status)
+	}
+	if file == "" {
+		file = currentSettings().ErrorPages.lookup(status)
+	}
+	if file != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(errorPagesDir, file)
+	}
+	return file
+}
+
+// loadErrorTemplate returns the parsed template file, it is parsed again if the file changed.
+func loadErrorTemplate(file string) (*template.Template, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	errorTemplatesLock.Lock()
+	defer errorTemplatesLock.Unlock()
+	if cached, ok := errorTemplates[file]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.template, nil
+	}
+	tmpl, err := template.ParseFiles(file)
+	if err != nil {
+		return nil, err
+	}
+	errorTemplates[file] = &errorTemplate{modTime: info.ModTime(), template: tmpl}
+	return tmpl, nil
+}
+
+// writeError sends an error to the client: a JSON body if the client asks for it, the error page of the backend (or the
+// global one) if there is one, or the message as text. The backend can be nil.
+func writeError(rw http.ResponseWriter, req *http.Request, backend *Backend, status int, message string) {
+	writeErrorPage(rw, req, errorPageFile(backend, status), status, message)
+}
+
+// writeErrorPage sends an error to the client with the given template file, the message is sent as text if the file is
+// empty or broken.
+func writeErrorPage(rw http.ResponseWriter, req *http.Request, file string, status int, message string) {
+	page := &ErrorPage{
+		Host:      req.Host,
+		Status:    status,
+		Error:     message,
+		RequestID: requestID(req),
+		Time:      time.Now(),
+	}
+	rw.Header().Set("X-Request-ID", page.RequestID)
+
+	if acceptsJSON(req) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(page)
+		return
+	}
+
+	if file != "" {
+		// rendered in a buffer, so a broken template gives the default error
+		buf := &bytes.Buffer{}
+		tmpl, err := loadErrorTemplate(file)
+		if err == nil {
+			err = tmpl.Execute(buf, page)
+		}
+		if err == nil {
+			rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+			rw.WriteHeader(status)
+			rw.Write(buf.Bytes())
+			return
+		}
+		log.Println("Error page", file, "failed:", err)
+	}
+
+	http.Error(rw, message, status)
+}
+
+// acceptsJSON returns true if the client prefers JSON to HTML, following the Accept header.
+func acceptsJSON(req *http.Request) bool {
+	jsonQ, htmlQ := 0.0, 0.0
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			if q > jsonQ {
+				jsonQ = q
+			}
+		case mediaType == "text/html":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		}
+	}
+	return jsonQ > htmlQ
+}
+
+// requestID returns the identifier of the request, given by the client or a proxy in X-Request-ID, or a new one that is
+// set in the request headers to be sent to the upstream.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(b)
+	req.Header.Set("X-Request-ID", id)
+	return id
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorPagesLookup(t *testing.T) {
+	pages := ErrorPages{
+		"404":     "404.html",
+		"5xx":     "5xx.html",
+		"502-504": "gateway.html",
+		"503":     "503.html",
+	}
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{status: 404, want: "404.html"},
+		{status: 503, want: "503.html"},
+		{status: 502, want: "gateway.html"},
+		{status: 504, want: "gateway.html"},
+		{status: 500, want: "5xx.html"},
+		{status: 599, want: "5xx.html"},
+		{status: 403, want: ""},
+	}
+	for _, test := range tests {
+		if file := pages.lookup(test.status); file != test.want {
+			t.Errorf("lookup %d: got %q, want %q", test.status, file, test.want)
+		}
+	}
+}
+
+func TestErrorPageFile(t *testing.T) {
+	defer settings.Store(currentSettings())
+	settings.Store(&Settings{ErrorPages: ErrorPages{"4xx": "4xx.html", "5xx": "/srv/5xx.html"}})
+	backend := &Backend{ErrorPages: ErrorPages{"404": "app/404.html"}}
+
+	tests := []struct {
+		backend *Backend
+		status  int
+		want    string
+	}{
+		{backend: backend, status: 404, want: "/errors/app/404.html"},
+		{backend: backend, status: 403, want: "/errors/4xx.html"},
+		{backend: backend, status: 502, want: "/srv/5xx.html"},
+		{backend: nil, status: 404, want: "/errors/4xx.html"},
+		{backend: backend, status: 302, want: ""},
+	}
+	for _, test := range tests {
+		if file := errorPageFile(test.backend, test.status); file != test.want {
+			t.Errorf("error page of %d: got %q, want %q", test.status, file, test.want)
+		}
+	}
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "*/*", want: false},
+		{accept: "application/json", want: true},
+		{accept: "application/problem+json", want: true},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: false},
+		{accept: "application/json, text/html", want: false},
+		{accept: "text/html;q=0.5, application/json", want: true},
+		{accept: "application/json;q=0.1, text/html;q=0.2", want: false},
+		{accept: "application/json;q=bad, text/plain", want: false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "http://app.localhost/", nil)
+		req.Header.Set("Accept", test.accept)
+		if got := acceptsJSON(req); got != test.want {
+			t.Errorf("Accept %q: got %v, want %v", test.accept, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerPlaceholders are the placeholders that can be used in the header values.
+var headerPlaceholders = []string{"client_ip", "host", "scheme", "request_id"}
+
+// HeaderRules change the headers of a request or a response. Headers are removed, then set, then added. Values can use
+// the {client_ip}, {host}, {scheme} and {request_id} placeholders.
+type HeaderRules struct {
+	// Set replaces the values of the headers.
+	Set map[string]string `yaml:"set,omitempty" json:"set,omitempty"`
+
+	// Add adds a value to the headers, the existing values are kept.
+	Add map[string]string `yaml:"add,omitempty" json:"add,omitempty"`
+
+	// Remove removes the headers.
+	Remove []string `yaml:"remove,omitempty" json:"remove,omitempty"`
+}
+
+// validate checks the header names and the placeholders of the values.
+func (rules *HeaderRules) validate(field string) ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if rules == nil {
+		return errs
+	}
+	check := func(action string, values map[string]string) {
+		for name, value := range values {
+			if !validHeaderName(name) {
+				errs = append(errs, &ConfigError{Field: field + "." + action, Message: "invalid header name " + name})
+			}
+			for _, p := range placeholders(value) {
+				if !contains(headerPlaceholders, p) {
+					errs = append(errs, &ConfigError{
+						Field:   field + "." + action,
+						Message: "unknown placeholder {" + p + "} in " + name + ", use " + strings.Join(headerPlaceholders, ", "),
+					})
+				}
+			}
+		}
+	}
+	check("set", rules.Set)
+	check("add", rules.Add)
+	for _, name := range rules.Remove {
+		if !validHeaderName(name) {
+			errs = append(errs, &ConfigError{Field: field + ".remove", Message: "invalid header name " + name})
+		}
+	}
+	return errs
+}
+
+// apply changes the headers, the values are the placeholders substitutions.
+func (rules *HeaderRules) apply(header http.Header, values map[string]string) {
+	if rules == nil {
+		return
+	}
+	for _, name := range rules.Remove {
+		header.Del(name)
+	}
+	for name, value := range rules.Set {
+		header.Set(name, substitute(value, values))
+	}
+	for name, value := range rules.Add {
+		header.Add(name, substitute(value, values))
+	}
+}
+
+// headerValues returns the values of the header placeholders for the request.
+func headerValues(req *http.Request) map[string]string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return map[string]string{
+		"client_ip":  clientIP(req),
+		"host":       req.Host,
+		"scheme":     scheme,
+		"request_id": requestID(req),
+	}
+}
+
+// validHeaderName returns true if the name can be used as an HTTP header name.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
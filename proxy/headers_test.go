@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderRulesApply(t *testing.T) {
+	values := map[string]string{"client_ip": "192.0.2.1", "host": "app.localhost", "scheme": "https", "request_id": "abc"}
+	tests := []struct {
+		name   string
+		rules  *HeaderRules
+		header http.Header
+		want   http.Header
+	}{
+		{
+			name:   "no rules",
+			header: http.Header{"X-Kept": {"1"}},
+			want:   http.Header{"X-Kept": {"1"}},
+		},
+		{
+			name:   "remove",
+			rules:  &HeaderRules{Remove: []string{"server", "X-Missing"}},
+			header: http.Header{"Server": {"nginx"}, "X-Kept": {"1"}},
+			want:   http.Header{"X-Kept": {"1"}},
+		},
+		{
+			name:   "set replaces the values",
+			rules:  &HeaderRules{Set: map[string]string{"X-Real-IP": "{client_ip}"}},
+			header: http.Header{"X-Real-Ip": {"10.0.0.1", "10.0.0.2"}},
+			want:   http.Header{"X-Real-Ip": {"192.0.2.1"}},
+		},
+		{
+			name:   "add keeps the values",
+			rules:  &HeaderRules{Add: map[string]string{"Via": "pathwae {scheme}://{host}"}},
+			header: http.Header{"Via": {"1.1 cache"}},
+			want:   http.Header{"Via": {"1.1 cache", "pathwae https://app.localhost"}},
+		},
+		{
+			name: "removed, then set, then added",
+			rules: &HeaderRules{
+				Remove: []string{"X-Trace"},
+				Set:    map[string]string{"X-Trace": "{request_id}"},
+				Add:    map[string]string{"X-Trace": "proxy"},
+			},
+			header: http.Header{"X-Trace": {"upstream"}},
+			want:   http.Header{"X-Trace": {"abc", "proxy"}},
+		},
+	}
+	for _, test := range tests {
+		test.rules.apply(test.header, values)
+		if !reflect.DeepEqual(test.header, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.header, test.want)
+		}
+	}
+}
+
+func TestProxyHeaders(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = req.Header
+		rw.Header().Set("Server", "app/1.2.3")
+		rw.Header().Set("X-Powered-By", "php")
+	}))
+	defer upstream.Close()
+
+	defer table.Store(currentTable())
+	enabled := true
+	b := &Backend{
+		To:              Upstreams{{URL: upstream.URL}},
+		Enabled:         &enabled,
+		RequestHeaders:  &HeaderRules{Set: map[string]string{"X-Client": "{client_ip}"}, Remove: []string{"Cookie"}},
+		ResponseHeaders: &HeaderRules{Add: map[string]string{"X-Proxy": "pathwae"}, Remove: []string{"Server", "X-Powered-By"}},
+	}
+	b.prepare("headers.localhost")
+	table.Store(newRoutingTable(map[string]*Backend{"headers.localhost": b}))
+
+	req := httptest.NewRequest("GET", "http://headers.localhost/", nil)
+	req.Header.Set("Cookie", "session=1")
+	rw := httptest.NewRecorder()
+	(&ReverseProxy{}).ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rw.Code)
+	}
+	if received.Get("X-Client") != "192.0.2.1" || received.Get("Cookie") != "" {
+		t.Errorf("the upstream got X-Client %q and Cookie %q", received.Get("X-Client"), received.Get("Cookie"))
+	}
+	if rw.Header().Get("X-Proxy") != "pathwae" || rw.Header().Get("Server") != "" || rw.Header().Get("X-Powered-By") != "" {
+		t.Errorf("got the response headers %v", rw.Header())
+	}
+}
@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health check defaults.
+const (
+	defaultHealthInterval = 5 * time.Second
+	defaultHealthTimeout  = 2 * time.Second
+	defaultHealthRise     = 2
+	defaultHealthFall     = 3
+	defaultHealthStatus   = "200-399"
+
+	// healthMaxBody is the part of the response body where the expected body is searched.
+	healthMaxBody = 64 * 1024
+)
+
+// Health of the backends and the upstreams.
+const (
+	HealthUp      = "up"
+	HealthDown    = "down"
+	HealthUnknown = "unknown" // not checked yet, or url with placeholders
+)
+
+// HealthCheck checks the upstreams of a backend in background. The unhealthy upstreams don't get requests, unless they
+// are all unhealthy. Without path, the check only opens a connection.
+type HealthCheck struct {
+	// Path is requested with GET on each upstream, like "/healthz".
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+
+	// Status is the expected status code, class or range of the response, "200-399" by default.
+	Status string `yaml:"status,omitempty" json:"status,omitempty"`
+
+	// Body is a text that must be in the response body.
+	Body string `yaml:"body,omitempty" json:"body,omitempty"`
+
+	// Interval is the time between two checks, 5s by default.
+	Interval Duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+
+	// Timeout is the time to wait for the response, 2s by default.
+	Timeout Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	// Rise is the number of successful checks for an unhealthy upstream to be healthy again, 2 by default.
+	Rise int `yaml:"rise,omitempty" json:"rise,omitempty"`
+
+	// Fall is the number of failed checks for a healthy upstream to be unhealthy, 3 by default.
+	Fall int `yaml:"fall,omitempty" json:"fall,omitempty"`
+}
+
+// healthChecker checks an upstream of a backend until it is stopped.
+type healthChecker struct {
+	backend string
+	url     string
+	config  HealthCheck
+	state   *upstreamState
+	stop    chan struct{}
+
+	// consecutive results, only used by the checker goroutine
+	successes int
+	failures  int
+}
+
+var (
+	// healthCheckers are the running checkers, by backend and url.
+	healthCheckers = make(map[string]*healthChecker)
+	healthLock     sync.Mutex
+)
+
+// validate checks the health check settings.
+func (h *HealthCheck) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if h == nil {
+		return errs
+	}
+	if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+		errs = append(errs, &ConfigError{Field: "health_check.path", Message: "path must start with /"})
+	}
+	if h.Status != "" {
+		if _, _, err := parseStatusRange(h.Status); err != nil {
+			errs = append(errs, &ConfigError{Field: "health_check.status", Message: err.Error()})
+		}
+	}
+	if h.Path == "" && (h.Status != "" || h.Body != "") {
+		errs = append(errs, &ConfigError{Field: "health_check", Message: "status and body need a path to check"})
+	}
+	if h.Rise < 0 || h.Fall < 0 {
+		errs = append(errs, &ConfigError{Field: "health_check", Message: "rise and fall can't be negative"})
+	}
+	return errs
+}
+
+// syncHealthChecks starts the checkers of the new upstreams, and stops the ones that are not used anymore or whose
+// settings changed. The upstreams of the disabled backends and the urls with placeholders are not checked.
+func syncHealthChecks(backends map[string]*Backend) {
+	wanted := make(map[string]*healthChecker)
+	for name, b := range backends {
+		if b.Enabled == nil || !*b.Enabled {
+			continue
+		}
+		config := HealthCheck{}
+		if b.HealthCheck != nil {
+			config = *b.HealthCheck
+		}
+		for _, u := range b.upstreams() {
+			if len(placeholders(u.URL)) > 0 {
+				continue
+			}
+			wanted[name+" "+u.URL] = &healthChecker{backend: name, url: u.URL, config: config}
+		}
+	}
+
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	for key, c := range healthCheckers {
+		if w, ok := wanted[key]; !ok || w.config != c.config {
+			close(c.stop)
+			delete(healthCheckers, key)
+		}
+	}
+	for key, c := range wanted {
+		if _, ok := healthCheckers[key]; ok {
+			continue
+		}
+		c.state = getUpstreamState(c.backend, c.url)
+		c.stop = make(chan struct{})
+		healthCheckers[key] = c
+		go c.run()
+	}
+}
+
+// upstreams returns the upstreams of the backend, with the backups, and of its routes.
+func (b *Backend) upstreams() Upstreams {
+	upstreams := append(append(Upstreams{}, b.To...), b.Backup...)
+	for _, route := range b.Routes {
+		upstreams = append(upstreams, route.To...)
+	}
+	return upstreams
+}
+
+// run checks the upstream at each interval, the first check sets the state without waiting for rise or fall.
+func (c *healthChecker) run() {
+	ticker := time.NewTicker(c.config.Interval.or(defaultHealthInterval))
+	defer ticker.Stop()
+
+	c.update(c.probe(), true)
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.update(c.probe(), false)
+		}
+	}
+}
+
+// update records the result of a check, and changes the state once the rise or fall threshold is reached.
+func (c *healthChecker) update(err error, first bool) {
+	rise, fall := c.config.Rise, c.config.Fall
+	if rise == 0 {
+		rise = defaultHealthRise
+	}
+	if fall == 0 {
+		fall = defaultHealthFall
+	}
+
+	healthy := c.state.isHealthy()
+	if err == nil {
+		c.successes, c.failures = c.successes+1, 0
+		c.state.healthError.Store("")
+		if first || (!healthy && c.successes >= rise) {
+			c.state.setHealthy(true)
+		}
+	} else {
+		c.successes, c.failures = 0, c.failures+1
+		c.state.healthError.Store(err.Error())
+		if first || (healthy && c.failures >= fall) {
+			c.state.setHealthy(false)
+		}
+	}
+	atomic.StoreInt32(&c.state.checked, 1)
+
+	if now := c.state.isHealthy(); now != healthy || first {
+		if now {
+			log.Printf("Upstream %s of %s is healthy", c.url, c.backend)
+		} else {
+			log.Printf("Upstream %s of %s is unhealthy: %v", c.url, c.backend, err)
+		}
+	}
+}
+
+// probe checks the upstream once: the directory must exist for the file urls, the connection must be accepted, and the
+// response to the path must be the expected one.
+func (c *healthChecker) probe() error {
+	to, err := parseURL(c.url)
+	if err != nil {
+		return err
+	}
+	if isFileURL(to) {
+		info, err := os.Stat(to.Path)
+		if err == nil && !info.IsDir() {
+			err = errors.New(to.Path + " is not a directory")
+		}
+		return err
+	}
+
+	timeout := c.config.Timeout.or(defaultHealthTimeout)
+	if c.config.Path == "" {
+		port := to.Port()
+		if port == "" {
+			port = "80"
+			if to.Scheme == "https" {
+				port = "443"
+			}
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(to.Hostname(), port), timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if b := GetBackend(c.backend); b != nil {
+		transport = getTransport(c.backend, b.Timeouts)
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(to.Scheme + "://" + to.Host + c.config.Path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	status := c.config.Status
+	if status == "" {
+		status = defaultHealthStatus
+	}
+	first, last, _ := parseStatusRange(status)
+	if resp.StatusCode < first || resp.StatusCode > last {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, healthMaxBody))
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if c.config.Body != "" {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, healthMaxBody))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), c.config.Body) {
+			return fmt.Errorf("%q not found in the response", c.config.Body)
+		}
+	}
+	return nil
+}
+
+// isHealthy returns true if the upstream can get requests, the upstreams that are not checked are healthy.
+func (s *upstreamState) isHealthy() bool {
+	return atomic.LoadInt32(&s.healthy) == 1
+}
+
+// setHealthy changes the health of the upstream.
+func (s *upstreamState) setHealthy(healthy bool) {
+	value := int32(0)
+	if healthy {
+		value = 1
+	}
+	atomic.StoreInt32(&s.healthy, value)
+}
+
+// health returns the health of the upstream, unknown until it is checked.
+func (s *upstreamState) health() string {
+	switch {
+	case atomic.LoadInt32(&s.checked) == 0:
+		return HealthUnknown
+	case s.isHealthy():
+		return HealthUp
+	default:
+		return HealthDown
+	}
+}
+
+// Health returns the health of the backend: up if one of its upstreams passed the health checks, unknown if none of
+// them is checked (the urls with placeholders are never checked), down otherwise or if the backend is disabled. The
+// checks are made in background, this returns the last result.
+func Health(backendName string) string {
+	b := GetBackend(backendName)
+	if b == nil || b.Enabled == nil || !*b.Enabled {
+		return HealthDown
+	}
+	health := HealthUnknown
+	for _, u := range b.upstreams() {
+		if len(placeholders(u.URL)) > 0 {
+			continue
+		}
+		switch getUpstreamState(backendName, u.URL).health() {
+		case HealthUp:
+			return HealthUp
+		case HealthDown:
+			health = HealthDown
+		}
+	}
+	return health
+}
+
+// IsHealthy returns false if the backend is disabled or if all its checked upstreams failed the health checks. A
+// backend whose upstreams are not checked is not reported as down.
+func IsHealthy(backendName string) bool {
+	return Health(backendName) != HealthDown
+}
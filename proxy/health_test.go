@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	defer table.Store(currentTable())
+
+	enabled, disabled := true, false
+	backends := map[string]*Backend{
+		"up.localhost":       {To: Upstreams{{URL: "http://up-a"}, {URL: "http://up-b"}}, Enabled: &enabled},
+		"down.localhost":     {To: Upstreams{{URL: "http://down"}}, Enabled: &enabled},
+		"new.localhost":      {To: Upstreams{{URL: "http://new"}}, Enabled: &enabled},
+		"disabled.localhost": {To: Upstreams{{URL: "http://up-a"}}, Enabled: &disabled},
+		"*.app.localhost":    {To: Upstreams{{URL: "http://{1}:8080"}}, Enabled: &enabled},
+		"mixed.localhost": {
+			To:      Upstreams{{URL: "http://down"}},
+			Routes:  []*Route{{Path: "/api", To: Upstreams{{URL: "http://{0}/api"}}}},
+			Enabled: &enabled,
+		},
+	}
+	table.Store(newRoutingTable(backends))
+
+	check := func(backend, url string, healthy bool) {
+		state := getUpstreamState(backend, url)
+		state.setHealthy(healthy)
+		atomic.StoreInt32(&state.checked, 1)
+	}
+	check("up.localhost", "http://up-a", false)
+	check("up.localhost", "http://up-b", true)
+	check("down.localhost", "http://down", false)
+	check("disabled.localhost", "http://up-a", true)
+	check("mixed.localhost", "http://down", false)
+
+	tests := map[string]string{
+		"up.localhost":       HealthUp,
+		"down.localhost":     HealthDown,
+		"new.localhost":      HealthUnknown,
+		"disabled.localhost": HealthDown,
+		"*.app.localhost":    HealthUnknown,
+		"mixed.localhost":    HealthDown,
+		"missing.localhost":  HealthDown,
+	}
+	for name, health := range tests {
+		if h := Health(name); h != health {
+			t.Errorf("%s: health %s, want %s", name, h, health)
+		}
+		if IsHealthy(name) != (health != HealthDown) {
+			t.Errorf("%s: IsHealthy is %v with health %s", name, IsHealthy(name), health)
+		}
+	}
+}
+
+func TestHealthCheckersPruned(t *testing.T) {
+	defer resetHistory()()
+	defer replaceBackends(OriginReload, currentTable().backends)
+
+	loadTestConfig(t, `a.localhost:
+  to: [http://127.0.0.1:1, http://127.0.0.1:2]
+b.localhost:
+  to: http://127.0.0.1:3
+c.localhost:
+  to: http://127.0.0.1:4
+`)
+	healthLock.Lock()
+	removed := []*healthChecker{
+		healthCheckers["a.localhost http://127.0.0.1:2"],
+		healthCheckers["b.localhost http://127.0.0.1:3"],
+		healthCheckers["c.localhost http://127.0.0.1:4"],
+	}
+	healthLock.Unlock()
+	for _, c := range removed {
+		if c == nil {
+			t.Fatal("the checkers are not started")
+		}
+	}
+
+	// an upstream and a backend are removed, a backend is disabled
+	loadTestConfig(t, `a.localhost:
+  to: http://127.0.0.1:1
+c.localhost:
+  to: http://127.0.0.1:4
+  enabled: false
+`)
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	if len(healthCheckers) != 1 || healthCheckers["a.localhost http://127.0.0.1:1"] == nil {
+		t.Errorf("got the checkers %v, want only the one of a.localhost http://127.0.0.1:1", healthCheckers)
+	}
+	for _, c := range removed {
+		select {
+		case <-c.stop:
+		default:
+			t.Errorf("the checker of %s %s is not stopped", c.backend, c.url)
+		}
+	}
+}
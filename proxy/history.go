@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxHistory is the number of revisions kept in the history.
+const maxHistory = 50
+
+var (
+	// ErrRevisionNotFound is returned when rolling back to a revision that is not in the history.
+	ErrRevisionNotFound = errors.New("revision not found")
+
+	history     = make([]*Revision, 0, maxHistory)
+	revision    int
+	historyLock sync.Mutex
+)
+
+// Revision is a version of the routing table, with the changes made from the previous one.
+type Revision struct {
+	Revision int       `json:"revision"`
+	Time     time.Time `json:"time"`
+	Origin   string    `json:"origin"`
+	Changes  []*Change `json:"changes"`
+
+	// the backends of this revision, to rollback
+	backends map[string]*Backend
+}
+
+// recordRevision adds a revision to the history, the oldest one is removed if the history is full.
+func recordRevision(origin string, changes []*Change, backends map[string]*Backend) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	revision++
+	if len(history) == maxHistory {
+		history = append(history[:0], history[1:]...)
+	}
+	history = append(history, &Revision{
+		Revision: revision,
+		Time:     time.Now(),
+		Origin:   origin,
+		Changes:  changes,
+		backends: backends,
+	})
+}
+
+// GetHistory returns the revisions of the routing table, the most recent first.
+func GetHistory() []*Revision {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	revisions := make([]*Revision, len(history))
+	for i, rev := range history {
+		revisions[len(history)-1-i] = rev
+	}
+	return revisions
+}
+
+// Rollback restores the backends of the given revision and returns the applied changes. The restoration is a new revision,
+// and the changes are sent to the changes listeners. It returns ErrRevisionNotFound if the revision is not in the history
+// anymore.
+func Rollback(rev int) ([]*Change, error) {
+	var backends map[string]*Backend
+	historyLock.Lock()
+	for _, r := range history {
+		if r.Revision == rev {
+			backends = r.backends
+		}
+	}
+	historyLock.Unlock()
+	if backends == nil {
+		return nil, ErrRevisionNotFound
+	}
+
+	log.Println("Rollback to revision", rev)
+	return replaceBackends(OriginRollback, backends), nil
+}
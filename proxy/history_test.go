@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetHistory empties the history for a test, the returned function restores it.
+func resetHistory() func() {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	saved, savedRevision := history, revision
+	history, revision = make([]*Revision, 0, maxHistory), 0
+	return func() {
+		historyLock.Lock()
+		defer historyLock.Unlock()
+		history, revision = saved, savedRevision
+	}
+}
+
+func TestHistoryRevisions(t *testing.T) {
+	defer resetHistory()()
+
+	const records = maxHistory + 5
+	for i := 0; i < records; i++ {
+		recordRevision(OriginAPI, []*Change{{Name: "app.localhost", Action: ChangeUpdate}}, nil)
+	}
+
+	revisions := GetHistory()
+	if len(revisions) != maxHistory {
+		t.Fatalf("got %d revisions, want %d", len(revisions), maxHistory)
+	}
+	for i, rev := range revisions {
+		if want := records - i; rev.Revision != want {
+			t.Fatalf("revision %d is numbered %d, want %d", i, rev.Revision, want)
+		}
+	}
+}
+
+func TestRollbackNotFound(t *testing.T) {
+	defer resetHistory()()
+
+	for i := 0; i < maxHistory+1; i++ {
+		recordRevision(OriginAPI, nil, map[string]*Backend{})
+	}
+	for _, rev := range []int{0, 1, maxHistory + 2} {
+		if _, err := Rollback(rev); err != ErrRevisionNotFound {
+			t.Errorf("rollback to %d: got %v, want ErrRevisionNotFound", rev, err)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	defer resetHistory()()
+	defer table.Store(currentTable())
+	defer func(src *configSource) { source = src }(source)
+	defer func(file string) { persistFile = file }(persistFile)
+
+	dir, err := ioutil.TempDir("", "pathwae")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	persistFile = filepath.Join(dir, "config.yaml")
+
+	content := `# the application
+app.localhost:
+  to: http://app:8080
+
+# the documentation
+docs.localhost:
+  to: http://docs:8080
+`
+	conf, err := parseYAMLConfig(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := RegisterChangesListener()
+	defer UnregisterChangesListener(listener)
+	setSource(content, conf.Backends)
+	replaceBackends(OriginFile, conf.Backends)
+	if err := DeleteBackend("docs.localhost"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Rollback(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Name != "docs.localhost" || changes[0].Action != ChangeCreate {
+		t.Fatalf("got changes %v, want the creation of docs.localhost", changes)
+	}
+
+	if _, ok := currentTable().backends["docs.localhost"]; !ok {
+		t.Error("docs.localhost is not restored")
+	}
+	revisions := GetHistory()
+	if len(revisions) != 3 || revisions[0].Revision != 3 || revisions[0].Origin != OriginRollback {
+		t.Errorf("the rollback is not the last revision: %v", revisions[0])
+	}
+	written, err := ioutil.ReadFile(persistFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(written), "docs.localhost:\n  to: http://docs:8080") {
+		t.Errorf("the restored host is not written:\n%s", written)
+	}
+	// the changes of docs.localhost are sent in order: loaded, deleted, restored
+	for _, want := range []string{ChangeCreate, ChangeDelete, ChangeCreate} {
+		select {
+		case change := <-listener:
+			for change.Name != "docs.localhost" {
+				change = <-listener
+			}
+			if change.Action != want {
+				t.Errorf("got change %s of docs.localhost, want %s", change.Action, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the %s of docs.localhost is not notified", want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultBackend is the name of the backend used for the hosts that are not configured.
+	DefaultBackend = "default"
+
+	// maxSuggestions is the number of "did you mean" suggestions in the index page.
+	maxSuggestions = 3
+)
+
+// indexTemplate is the page shown for an unknown host when there is no default backend.
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Pathwae - unknown host {{ .Host }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 50em; color: #333; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .4em; border-bottom: 1px solid #ddd; }
+.up { color: #198754; }
+.down { color: #dc3545; }
+.disabled, .unknown { color: #999; }
+</style>
+</head>
+<body>
+<h1>No backend for {{ .Host }}</h1>
+{{ if .Suggestions }}<p>Did you mean {{ range $i, $s := .Suggestions }}{{ if $i }}, {{ end }}<a href="{{ $s.URL }}">{{ $s.Name }}</a>{{ end }}?</p>{{ end }}
+<h2>Configured hosts</h2>
+{{ if .Hosts }}<table>
+<tr><th>Host</th><th>Enabled</th><th>Status</th></tr>
+{{ range .Hosts }}<tr>
+<td>{{ if .URL }}<a href="{{ .URL }}">{{ .Name }}</a>{{ else }}{{ .Name }}{{ end }}</td>
+<td>{{ if .Enabled }}yes{{ else }}<span class="disabled">no</span>{{ end }}</td>
+<td><span class="{{ .Health }}">{{ .Health }}</span></td>
+</tr>
+{{ end }}</table>{{ else }}<p>There is no configured host.</p>{{ end }}
+</body>
+</html>
+`))
+
+// indexHost is a configured host in the index page.
+type indexHost struct {
+	Name    string
+	URL     string // empty for wildcard and regex hosts
+	Enabled bool
+	Health  string // up, down or unknown
+}
+
+// indexPage is the data of the index page.
+type indexPage struct {
+	Host        string
+	Hosts       []*indexHost
+	Suggestions []*indexHost
+}
+
+// serveIndex writes the list of the configured hosts, with suggestions for the requested host. The entrypoint is the one
+// serving the request.
+func serveIndex(rw http.ResponseWriter, req *http.Request, entrypoint string) {
+	backends := currentTable().backends
+	page := &indexPage{Host: req.Host}
+
+	for name, b := range backends {
+		host := &indexHost{
+			Name:    name,
+			Enabled: b.Enabled != nil && *b.Enabled,
+			Health:  Health(name),
+		}
+		if !isRegexHost(name) && !isWildcardHost(name) {
+			host.URL = backendURL(name, b, req, entrypoint)
+		}
+		page.Hosts = append(page.Hosts, host)
+	}
+
+	sort.Slice(page.Hosts, func(i, j int) bool {
+		return page.Hosts[i].Name < page.Hosts[j].Name
+	})
+	page.Suggestions = suggestHosts(req.Host, page.Hosts)
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusNotFound)
+	if err := indexTemplate.Execute(rw, page); err != nil {
+		log.Println("Failed to write the index page:", err)
+	}
+}
+
+// backendURL returns the link to the backend. It is on the entrypoint of the request if it serves the backend, with the
+// port used by the client, else on the port of a started entrypoint serving the backend. It is empty if there is none.
+func backendURL(name string, b *Backend, req *http.Request, entrypoint string) string {
+	useTLS, port := req.TLS != nil, ""
+	if _, p, err := net.SplitHostPort(req.Host); err == nil {
+		port = p
+	}
+	if !b.servesEntrypoint(entrypoint) || (b.ForceSSL && !useTLS) {
+		found := false
+		for _, e := range listeningEntrypoints() {
+			if b.servesEntrypoint(e.name) && (e.tls || !b.ForceSSL) {
+				useTLS, port, found = e.tls, strconv.Itoa(e.port), true
+				if e.name == EntrypointHTTPS {
+					port = strconv.Itoa(httpsPort())
+				}
+				break
+			}
+		}
+		if !found {
+			return ""
+		}
+	}
+
+	scheme, defaultPort := "http", "80"
+	if useTLS {
+		scheme, defaultPort = "https", "443"
+	}
+	if port != "" && port != defaultPort {
+		name = net.JoinHostPort(name, port)
+	}
+	return scheme + "://" + name + "/"
+}
+
+// suggestHosts returns the configured hosts that are close to the requested one.
+func suggestHosts(requested string, hosts []*indexHost) []*indexHost {
+	requested = hostName(requested)
+
+	type suggestion struct {
+		host     *indexHost
+		distance int
+	}
+	suggestions := make([]suggestion, 0)
+	for _, host := range hosts {
+		if host.URL == "" {
+			continue
+		}
+		// accept one typo for 4 characters, at least 2
+		maxDistance := len(host.Name) / 4
+		if maxDistance < 2 {
+			maxDistance = 2
+		}
+		if d := levenshtein(requested, strings.ToLower(host.Name)); d <= maxDistance {
+			suggestions = append(suggestions, suggestion{host, d})
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].distance < suggestions[j].distance
+	})
+
+	result := make([]*indexHost, 0, maxSuggestions)
+	for i := 0; i < len(suggestions) && i < maxSuggestions; i++ {
+		result = append(result, suggestions[i].host)
+	}
+	return result
+}
+
+// levenshtein returns the edit distance between the two strings.
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// minInt returns the smallest of the values.
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
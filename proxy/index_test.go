@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBackendURL(t *testing.T) {
+	listeningLock.Lock()
+	started := listening
+	listening = make(map[string]*listeningEntrypoint)
+	listeningLock.Unlock()
+	defer func() {
+		listeningLock.Lock()
+		listening = started
+		listeningLock.Unlock()
+	}()
+	SetEntrypointAddress(EntrypointHTTP, ":10080", false)
+	SetEntrypointAddress(EntrypointHTTPS, ":10443", true)
+	SetEntrypointAddress("admin", "127.0.0.1:9000", false)
+	SetEntrypointAddress("secure", "[::1]:9443", true)
+
+	tests := []struct {
+		name       string
+		backend    *Backend
+		host       string
+		entrypoint string
+		url        string
+	}{
+		{name: "same entrypoint", backend: &Backend{}, host: "unknown.localhost:10080", url: "http://app.localhost:10080/"},
+		{name: "default port", backend: &Backend{}, host: "unknown.localhost", url: "http://app.localhost/"},
+		{
+			name: "tls entrypoint", backend: &Backend{}, host: "unknown.localhost:10443", entrypoint: EntrypointHTTPS,
+			url: "https://app.localhost:10443/",
+		},
+		{name: "force ssl", backend: &Backend{ForceSSL: true}, host: "unknown.localhost:10080", url: "https://app.localhost:10443/"},
+		{
+			name: "other entrypoint", backend: &Backend{Entrypoints: []string{"admin"}}, host: "unknown.localhost:10080",
+			url: "http://app.localhost:9000/",
+		},
+		{
+			name: "other tls entrypoint", backend: &Backend{Entrypoints: []string{"admin", "secure"}, ForceSSL: true},
+			host: "unknown.localhost:10080", url: "https://app.localhost:9443/",
+		},
+		{
+			name: "https only", backend: &Backend{Entrypoints: []string{EntrypointHTTPS}}, host: "unknown.localhost",
+			url: "https://app.localhost:10443/",
+		},
+		{name: "not started", backend: &Backend{Entrypoints: []string{"stopped"}}, host: "unknown.localhost"},
+	}
+	for _, test := range tests {
+		entrypoint := test.entrypoint
+		if entrypoint == "" {
+			entrypoint = EntrypointHTTP
+		}
+		req := httptest.NewRequest("GET", "http://unknown.localhost/", nil)
+		req.Host = test.host
+		if entrypoint == EntrypointHTTPS {
+			req.TLS = &tls.ConnectionState{}
+		}
+		if url := backendURL("app.localhost", test.backend, req, entrypoint); url != test.url {
+			t.Errorf("%s: link %q, want %q", test.name, url, test.url)
+		}
+	}
+}
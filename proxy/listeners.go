@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"crypto/tls"
-	"net/http"
 )
 
 var (
@@ -43,7 +42,7 @@ func UnregisterCertListener(cl chan *tls.Certificate) {
 func RegisterChangesListener() chan *Change {
 	changeLock.Lock()
 	defer changeLock.Unlock()
-	listener := make(chan *Change)
+	listener := make(chan *Change, 100)
 	changesListeners = append(changesListeners, listener)
 	return listener
 }
@@ -87,18 +86,16 @@ func UnregisterStatsListener(ch chan *Stat) {
 }
 
 // doStats sends the stats to all registered listeners.
-func doStat(req http.Request) {
+func doStat(stat *Stat) {
 	lock.Lock()
 	defer lock.Unlock()
-	hostname := req.Host
-	path := req.URL.Path
-	method := req.Method
+	hostname := stat.host
 	// Send the stat to all listeners for this hostname
 	go func() {
 		for _, listener := range StatListeners[hostname] {
-			listener <- &Stat{path, method}
+			listener <- stat
 		}
 	}()
 	// Add the stat to the hostname's list of stats
-	Stats[hostname] = append(Stats[hostname], &Stat{path, method})
+	Stats[hostname] = append(Stats[hostname], stat)
 }
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Maintenance answers "503 Service Unavailable" instead of proxying, while the backend is enabled or during the
+// scheduled windows. The developers can still reach the backend with the bypass rules.
+type Maintenance struct {
+	// Enabled starts the maintenance now, until it is disabled.
+	Enabled bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+
+	// Windows are the scheduled maintenances.
+	Windows []*MaintenanceWindow `yaml:"windows,omitempty" json:"windows,omitempty"`
+
+	// Page is the template of the maintenance page, relative to the error pages directory. The 503 error page is used by
+	// default.
+	Page string `yaml:"page,omitempty" json:"page,omitempty"`
+
+	// RetryAfter is sent to the clients in the Retry-After header. The end of the current window is used by default.
+	RetryAfter Duration `yaml:"retry_after,omitempty" json:"retry_after,omitempty"`
+
+	// Bypass are the requests that are proxied during the maintenance.
+	Bypass *MaintenanceBypass `yaml:"bypass,omitempty" json:"bypass,omitempty"`
+}
+
+// MaintenanceWindow is a scheduled maintenance, the times are like "2022-03-01T22:00:00Z".
+type MaintenanceWindow struct {
+	Start time.Time `yaml:"start" json:"start"`
+	End   time.Time `yaml:"end" json:"end"`
+}
+
+// MaintenanceBypass are the requests that are not concerned by the maintenance. A request is proxied if one of the rules
+// matches.
+type MaintenanceBypass struct {
+	// IPs are the client addresses or CIDRs.
+	IPs []string `yaml:"ips,omitempty" json:"ips,omitempty"`
+
+	// Cookies are the cookie names and values, an empty value accepts any value.
+	Cookies map[string]string `yaml:"cookies,omitempty" json:"cookies,omitempty"`
+
+	// Headers are the header names and values, an empty value accepts any value.
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+
+	// parsed IPs
+	ips []*net.IPNet
+}
+
+// validate checks the windows and parses the bypass addresses.
+func (m *Maintenance) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if m == nil {
+		return errs
+	}
+	for i, w := range m.Windows {
+		field := fmt.Sprintf("maintenance.windows[%d]", i)
+		if w == nil || w.Start.IsZero() || w.End.IsZero() {
+			errs = append(errs, &ConfigError{Field: field, Message: "start and end are required"})
+			continue
+		}
+		if !w.End.After(w.Start) {
+			errs = append(errs, &ConfigError{Field: field, Message: "end must be after start"})
+		}
+	}
+	if m.Bypass == nil {
+		return errs
+	}
+	var err error
+	if m.Bypass.ips, err = parseNetworks(m.Bypass.IPs); err != nil {
+		errs = append(errs, &ConfigError{Field: "maintenance.bypass.ips", Message: err.Error()})
+	}
+	for name := range m.Bypass.Headers {
+		if !validHeaderName(name) {
+			errs = append(errs, &ConfigError{Field: "maintenance.bypass.headers", Message: "invalid header name " + name})
+		}
+	}
+	return errs
+}
+
+// active returns true if the maintenance is in progress, with the end of the current window (zero if there is none).
+func (m *Maintenance) active(now time.Time) (bool, time.Time) {
+	if m == nil {
+		return false, time.Time{}
+	}
+	active, end := m.Enabled, time.Time{}
+	for _, w := range m.Windows {
+		if !now.Before(w.Start) && now.Before(w.End) {
+			active = true
+			if w.End.After(end) {
+				end = w.End
+			}
+		}
+	}
+	return active, end
+}
+
+// bypasses returns true if the request is proxied despite the maintenance.
+func (m *Maintenance) bypasses(req *http.Request) bool {
+	if m.Bypass == nil {
+		return false
+	}
+	if containsIP(m.Bypass.ips, net.ParseIP(clientIP(req))) {
+		return true
+	}
+	for name, value := range m.Bypass.Cookies {
+		if c, err := req.Cookie(name); err == nil && matchesSecret(c.Value, value) {
+			return true
+		}
+	}
+	for name, value := range m.Bypass.Headers {
+		if header := req.Header.Get(name); header != "" && matchesSecret(header, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSecret returns true if the expected value is empty or equal to the given one, in constant time.
+func matchesSecret(given, expected string) bool {
+	return expected == "" || subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// underMaintenance sends the maintenance page if the backend is in maintenance and the request doesn't bypass it. It
+// returns true if the response is sent.
+func (b *Backend) underMaintenance(rw http.ResponseWriter, req *http.Request) bool {
+	now := time.Now()
+	active, end := b.Maintenance.active(now)
+	if !active || b.Maintenance.bypasses(req) {
+		return false
+	}
+
+	switch {
+	case b.Maintenance.RetryAfter > 0:
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Duration(b.Maintenance.RetryAfter).Seconds()))))
+	case !end.IsZero():
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(end.Sub(now).Seconds()))))
+	}
+
+	file := b.Maintenance.Page
+	if file != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(errorPagesDir, file)
+	}
+	if file == "" {
+		file = errorPageFile(b, http.StatusServiceUnavailable)
+	}
+	writeErrorPage(rw, req, file, http.StatusServiceUnavailable, "backend is under maintenance")
+	return true
+}
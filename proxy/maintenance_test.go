@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUnderMaintenance(t *testing.T) {
+	now := time.Now()
+	bypass := &MaintenanceBypass{
+		IPs:     []string{"10.0.0.0/8"},
+		Cookies: map[string]string{"maintenance": "s3cret"},
+		Headers: map[string]string{"X-Dev": ""},
+	}
+	tests := []struct {
+		name        string
+		maintenance *Maintenance
+		request     func(req *http.Request)
+		served      bool
+		retryAfter  string
+	}{
+		{name: "no maintenance", maintenance: nil},
+		{name: "disabled", maintenance: &Maintenance{}},
+		{name: "enabled", maintenance: &Maintenance{Enabled: true}, served: true},
+		{
+			name:        "retry after",
+			maintenance: &Maintenance{Enabled: true, RetryAfter: Duration(90 * time.Second)},
+			served:      true,
+			retryAfter:  "90",
+		},
+		{
+			name:        "current window",
+			maintenance: &Maintenance{Windows: []*MaintenanceWindow{{Start: now.Add(-time.Minute), End: now.Add(10 * time.Minute)}}},
+			served:      true,
+			retryAfter:  "600",
+		},
+		{
+			name:        "past window",
+			maintenance: &Maintenance{Windows: []*MaintenanceWindow{{Start: now.Add(-time.Hour), End: now.Add(-time.Minute)}}},
+		},
+		{
+			name:        "allowed address",
+			maintenance: &Maintenance{Enabled: true, Bypass: bypass},
+			request:     func(req *http.Request) { req.RemoteAddr = "10.1.2.3:1234" },
+		},
+		{
+			name:        "other address",
+			maintenance: &Maintenance{Enabled: true, Bypass: bypass},
+			served:      true,
+		},
+		{
+			name:        "bypass cookie",
+			maintenance: &Maintenance{Enabled: true, Bypass: bypass},
+			request:     func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "maintenance", Value: "s3cret"}) },
+		},
+		{
+			name:        "wrong cookie",
+			maintenance: &Maintenance{Enabled: true, Bypass: bypass},
+			request:     func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "maintenance", Value: "guess"}) },
+			served:      true,
+		},
+		{
+			name:        "bypass header",
+			maintenance: &Maintenance{Enabled: true, Bypass: bypass},
+			request:     func(req *http.Request) { req.Header.Set("X-Dev", "1") },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errs := test.maintenance.validate(); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			backend := &Backend{Maintenance: test.maintenance}
+			req := httptest.NewRequest("GET", "http://app.localhost/", nil)
+			if test.request != nil {
+				test.request(req)
+			}
+			rec := httptest.NewRecorder()
+			served := backend.underMaintenance(rec, req)
+			if served != test.served {
+				t.Fatalf("got maintenance %v, want %v", served, test.served)
+			}
+			if !served {
+				return
+			}
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("got status %d, want 503", rec.Code)
+			}
+			if retryAfter := rec.Header().Get("Retry-After"); retryAfter != test.retryAfter {
+				t.Errorf("got Retry-After %q, want %q", retryAfter, test.retryAfter)
+			}
+		})
+	}
+}
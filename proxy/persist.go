@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// persistFile is the file where the API changes are written, persistence is disabled if empty.
+	persistFile string
+
+	// source is the last loaded configuration content, used to keep the comments and the order of the file.
+	source     *configSource
+	sourceLock sync.Mutex
+)
+
+// configSource is a loaded configuration file, split in blocks of lines per host.
+type configSource struct {
+	header   []string
+	footer   []string // comments after the last host
+	blocks   map[string]*configBlock
+	backends map[string]*Backend
+}
+
+// configBlock is the text of a host in the configuration file.
+type configBlock struct {
+	comments []string // comments and blank lines before the host
+	lines    []string // the host and its configuration
+	line     int      // line number of the host
+}
+
+// EnablePersistence writes the changes made through the API to the given configuration file.
+func EnablePersistence(path string) {
+	log.Println("API changes will be written in", path)
+	persistFile = path
+}
+
+// setSource records the loaded configuration content.
+func setSource(content string, backends map[string]*Backend) {
+	cl := newConfigLines(content)
+	src := &configSource{
+		blocks:   make(map[string]*configBlock),
+		backends: backends,
+	}
+
+	// comments before a host belong to it, the others (at the top of the file) are the header
+	end := len(cl.lines)
+	for i := len(cl.hosts) - 1; i >= 0; i-- {
+		h := cl.hosts[i]
+		last := end
+		for last > h.start+1 && isCommentLine(cl.lines[last-1]) {
+			last--
+		}
+		first := h.start
+		for first > 0 && isCommentLine(cl.lines[first-1]) {
+			first--
+		}
+		if i == len(cl.hosts)-1 {
+			src.footer = trimBlankLines(cl.lines[last:])
+		}
+		src.blocks[h.name] = &configBlock{
+			comments: cl.lines[first:h.start],
+			lines:    cl.lines[h.start:last],
+			line:     h.start + 1,
+		}
+		end = first
+	}
+	src.header = cl.lines[:end]
+	if len(cl.hosts) == 0 {
+		src.header = nil
+	}
+
+	sourceLock.Lock()
+	source = src
+	sourceLock.Unlock()
+}
+
+// trimBlankLines returns the lines without the blank lines at the end, the file ends with a new line anyway.
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// isCommentLine returns true if the line is empty or is a comment.
+func isCommentLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// ExportYAMLConfig returns the current configuration as YAML. The hosts are in the order of the configuration file, the
+// new ones at the end. Comments are kept for the hosts that were not changed, and before the changed ones. The global
+// settings are kept as they are written in the file.
+func ExportYAMLConfig() (string, error) {
+	return exportConfig(currentTable().backends)
+}
+
+// exportConfig writes the backends as YAML.
+func exportConfig(backends map[string]*Backend) (string, error) {
+	sourceLock.Lock()
+	src := source
+	sourceLock.Unlock()
+	if src == nil {
+		src = &configSource{}
+	}
+
+	// settings and hosts are sorted by line, the settings only exist in the file
+	order := make(map[string]int, len(backends))
+	for name, b := range backends {
+		order[name] = b.order
+	}
+	for name, block := range src.blocks {
+		if isSettingKey(name) {
+			order[name] = block.line
+		}
+	}
+	names := make([]string, 0, len(order))
+	for name := range order {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if order[names[i]] != order[names[j]] {
+			return order[names[i]] < order[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	lines := append([]string{}, src.header...)
+	for _, name := range names {
+		b, isBackend := backends[name]
+		if !isBackend {
+			block := src.blocks[name]
+			lines = append(lines, block.comments...)
+			lines = append(lines, block.lines...)
+			continue
+		}
+		block, ok := src.blocks[name]
+		if !ok && len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			// the new hosts are separated like the others
+			lines = append(lines, "")
+		}
+		if ok {
+			lines = append(lines, block.comments...)
+			if original, ok := src.backends[name]; ok && original.equal(b) {
+				lines = append(lines, block.lines...)
+				continue
+			}
+		}
+		out, err := yaml.Marshal(map[string]*Backend{name: b})
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, strings.TrimRight(string(out), "\n"))
+	}
+	lines = append(lines, src.footer...)
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// persist writes the backends in the configuration file if the persistence is enabled.
+func persist(backends map[string]*Backend) {
+	if persistFile == "" {
+		return
+	}
+	content, err := exportConfig(backends)
+	if err != nil {
+		log.Println("Failed to export the configuration:", err)
+		return
+	}
+	if err := writeFileAtomic(persistFile, []byte(content)); err != nil {
+		log.Println("Failed to write the configuration:", err)
+		return
+	}
+	// the written file is now the reference for the comments
+	setSource(content, backends)
+	log.Println("Configuration written in", persistFile)
+}
+
+// writeFileAtomic writes the file in a temporary file renamed at the end, so the file is never partially written.
+func writeFileAtomic(path string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails once renamed, that's OK
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
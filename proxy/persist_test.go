@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const persistConfig = `# the proxy of the team
+listeners:
+  http: ":8001"
+
+# the application
+app.localhost:
+  to: http://app:8080 # the main one
+
+# the documentation
+docs.localhost:
+  to: http://docs:8080
+  force_ssl: false
+
+# the end of the file
+`
+
+func TestExportConfig(t *testing.T) {
+	defer func(src *configSource) { source = src }(source)
+
+	tests := []struct {
+		name string
+		edit func(backends map[string]*Backend)
+		want string
+	}{
+		{
+			name: "unchanged",
+			edit: func(backends map[string]*Backend) {},
+			want: persistConfig,
+		},
+		{
+			name: "changed host",
+			edit: func(backends map[string]*Backend) {
+				b := *backends["app.localhost"]
+				b.ForceSSL = true
+				backends["app.localhost"] = &b
+			},
+			want: `# the proxy of the team
+listeners:
+  http: ":8001"
+
+# the application
+app.localhost:
+  to: http://app:8080
+  force_ssl: true
+  enabled: true
+
+# the documentation
+docs.localhost:
+  to: http://docs:8080
+  force_ssl: false
+
+# the end of the file
+`,
+		},
+		{
+			name: "new host",
+			edit: func(backends map[string]*Backend) {
+				b := &Backend{To: Upstreams{{URL: "http://new:8080"}}, order: 100}
+				b.setDefaults()
+				backends["new.localhost"] = b
+			},
+			want: `# the proxy of the team
+listeners:
+  http: ":8001"
+
+# the application
+app.localhost:
+  to: http://app:8080 # the main one
+
+# the documentation
+docs.localhost:
+  to: http://docs:8080
+  force_ssl: false
+
+new.localhost:
+  to: http://new:8080
+  force_ssl: false
+  enabled: true
+
+# the end of the file
+`,
+		},
+		{
+			name: "deleted host",
+			edit: func(backends map[string]*Backend) {
+				delete(backends, "app.localhost")
+			},
+			want: `# the proxy of the team
+listeners:
+  http: ":8001"
+
+# the documentation
+docs.localhost:
+  to: http://docs:8080
+  force_ssl: false
+
+# the end of the file
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf, err := parseYAMLConfig(persistConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setSource(persistConfig, conf.Backends)
+
+			backends := make(map[string]*Backend, len(conf.Backends))
+			for name, b := range conf.Backends {
+				backends[name] = b
+			}
+			test.edit(backends)
+			got, err := exportConfig(backends)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pathwae")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte(persistConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != persistConfig {
+		t.Errorf("got content %q", content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("got mode %v, want -rw-r-----", info.Mode().Perm())
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files, the temporary file is not removed", len(files))
+	}
+
+	// a new file is readable by all, like the files written by the editors
+	path = filepath.Join(dir, "new.yaml")
+	if err := writeFileAtomic(path, []byte(persistConfig)); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("got mode %v, want -rw-r--r--", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit keys.
+const (
+	RateLimitByIP     = "ip"
+	RateLimitGlobal   = "global"
+	RateLimitByHeader = "header:"
+)
+
+// rateLimitSweep is the interval to remove the full buckets, they are the same as new ones.
+const rateLimitSweep = time.Minute
+
+// RateLimit limits the requests sent to a backend with a token bucket: each request takes a token, the bucket gets Rate
+// tokens per second and holds at most Burst tokens.
+type RateLimit struct {
+	// Rate is the number of requests per second, can be less than 1 (0.5 is one request every 2 seconds).
+	Rate float64 `yaml:"rate" json:"rate"`
+
+	// Burst is the number of requests that can be made at once, the rate rounded up by default.
+	Burst int `yaml:"burst,omitempty" json:"burst,omitempty"`
+
+	// Key tells who is limited: "ip" (default) for each client address, "global" for all the clients together, or
+	// "header:Name" for each value of a header (the client address is used if the header is not set).
+	Key string `yaml:"key,omitempty" json:"key,omitempty"`
+}
+
+// RateLimitState is the state of the rate limit of a backend, returned by the API.
+type RateLimitState struct {
+	Rate    float64                 `json:"rate"`
+	Burst   int                     `json:"burst"`
+	Key     string                  `json:"key"`
+	Buckets []*RateLimitBucketState `json:"buckets"`
+}
+
+// RateLimitBucketState is the state of a client, the clients having all their tokens are not listed.
+type RateLimitBucketState struct {
+	Key    string  `json:"key"`
+	Tokens float64 `json:"tokens"`
+}
+
+// rateLimiter keeps the buckets of a backend.
+type rateLimiter struct {
+	config    RateLimit
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// tokenBucket is the number of tokens of a client at a given time.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	// ErrNoRateLimit is returned when getting the rate limit state of a backend without rate limit.
+	ErrNoRateLimit = errors.New("no rate limit for this backend")
+
+	// rateLimiters are the limiters by backend name, so the buckets survive to configuration changes.
+	rateLimiters     = make(map[string]*rateLimiter)
+	rateLimitersLock sync.Mutex
+)
+
+// validate checks the rate limit settings.
+func (r *RateLimit) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if r == nil {
+		return errs
+	}
+	if r.Rate <= 0 {
+		errs = append(errs, &ConfigError{Field: "rate_limit.rate", Message: "rate must be positive"})
+	}
+	if r.Burst < 0 {
+		errs = append(errs, &ConfigError{Field: "rate_limit.burst", Message: "burst can't be negative"})
+	}
+	switch {
+	case r.Key == "", r.Key == RateLimitByIP, r.Key == RateLimitGlobal:
+	case strings.HasPrefix(r.Key, RateLimitByHeader) && validHeaderName(r.Key[len(RateLimitByHeader):]):
+	default:
+		errs = append(errs, &ConfigError{
+			Field:   "rate_limit.key",
+			Message: "invalid key " + r.Key + ", use ip, global or header:Name",
+		})
+	}
+	return errs
+}
+
+// burst returns the size of the buckets.
+func (r *RateLimit) burst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return int(math.Max(1, math.Ceil(r.Rate)))
+}
+
+// key returns the bucket to use for the request.
+func (r *RateLimit) key(req *http.Request) string {
+	switch {
+	case r.Key == RateLimitGlobal:
+		return RateLimitGlobal
+	case strings.HasPrefix(r.Key, RateLimitByHeader):
+		if value := req.Header.Get(r.Key[len(RateLimitByHeader):]); value != "" {
+			return value
+		}
+	}
+	return clientIP(req)
+}
+
+// getRateLimiter returns the limiter of the backend, a new one is created if the settings changed.
+func getRateLimiter(name string, config *RateLimit) *rateLimiter {
+	rateLimitersLock.Lock()
+	defer rateLimitersLock.Unlock()
+	limiter, ok := rateLimiters[name]
+	if !ok || !reflect.DeepEqual(limiter.config, *config) {
+		limiter = &rateLimiter{
+			config:  *config,
+			buckets: make(map[string]*tokenBucket),
+		}
+		rateLimiters[name] = limiter
+	}
+	return limiter
+}
+
+// pruneRateLimiters removes the limiters of the backends that are deleted or have no rate limit anymore.
+func pruneRateLimiters(backends map[string]*Backend) {
+	rateLimitersLock.Lock()
+	defer rateLimitersLock.Unlock()
+	for name := range rateLimiters {
+		if b, ok := backends[name]; !ok || b.RateLimit == nil {
+			delete(rateLimiters, name)
+		}
+	}
+}
+
+// take takes a token for the key. It returns false if there is no token, the remaining tokens, and the time to wait
+// for the next token.
+func (l *rateLimiter) take(key string, now time.Time) (bool, int, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sweep(now)
+
+	burst := float64(l.config.burst())
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.config.Rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.config.Rate * float64(time.Second))
+		return false, 0, wait
+	}
+	bucket.tokens--
+	return true, int(bucket.tokens), 0
+}
+
+// sweep removes the buckets that are full, it is made at most once per rateLimitSweep.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweep {
+		return
+	}
+	l.lastSweep = now
+	burst := float64(l.config.burst())
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.config.Rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// state returns the state of the buckets that are not full.
+func (l *rateLimiter) state(now time.Time) *RateLimitState {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	burst := float64(l.config.burst())
+	state := &RateLimitState{
+		Rate:    l.config.Rate,
+		Burst:   l.config.burst(),
+		Key:     l.config.Key,
+		Buckets: make([]*RateLimitBucketState, 0),
+	}
+	if state.Key == "" {
+		state.Key = RateLimitByIP
+	}
+	for key, bucket := range l.buckets {
+		tokens := math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.config.Rate)
+		if tokens < burst {
+			state.Buckets = append(state.Buckets, &RateLimitBucketState{Key: key, Tokens: tokens})
+		}
+	}
+	sort.Slice(state.Buckets, func(i, j int) bool {
+		return state.Buckets[i].Key < state.Buckets[j].Key
+	})
+	return state
+}
+
+// limit takes a token for the request and sets the RateLimit headers. It returns false, with the Retry-After header, if
+// the request must be refused.
+func (b *Backend) limit(name string, rw http.ResponseWriter, req *http.Request) bool {
+	if b.RateLimit == nil {
+		return true
+	}
+	limiter := getRateLimiter(name, b.RateLimit)
+	allowed, remaining, wait := limiter.take(b.RateLimit.key(req), time.Now())
+
+	// the bucket is full again after this time
+	burst := b.RateLimit.burst()
+	reset := math.Ceil(float64(burst-remaining) / b.RateLimit.Rate)
+	rw.Header().Set("RateLimit-Limit", strconv.Itoa(burst))
+	rw.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	rw.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset)))
+	if !allowed {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	return allowed
+}
+
+// GetRateLimit returns the state of the rate limit of the backend. It returns ErrBackendNotFound if the backend doesn't
+// exist, or ErrNoRateLimit if it has no rate limit.
+func GetRateLimit(name string) (*RateLimitState, error) {
+	b := GetBackend(name)
+	if b == nil {
+		return nil, ErrBackendNotFound
+	}
+	if b.RateLimit == nil {
+		return nil, ErrNoRateLimit
+	}
+	return getRateLimiter(name, b.RateLimit).state(time.Now()), nil
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	limiter := &rateLimiter{
+		config:  RateLimit{Rate: 2, Burst: 3},
+		buckets: make(map[string]*tokenBucket),
+	}
+	start := time.Now()
+
+	tests := []struct {
+		after     time.Duration
+		key       string
+		allowed   bool
+		remaining int
+		wait      time.Duration
+	}{
+		// the burst is available at once
+		{after: 0, key: "a", allowed: true, remaining: 2},
+		{after: 0, key: "a", allowed: true, remaining: 1},
+		{after: 0, key: "a", allowed: true, remaining: 0},
+		{after: 0, key: "a", allowed: false, wait: 500 * time.Millisecond},
+		// the other clients have their own bucket
+		{after: 0, key: "b", allowed: true, remaining: 2},
+		// 2 tokens per second
+		{after: 250 * time.Millisecond, key: "a", allowed: false, wait: 250 * time.Millisecond},
+		{after: 500 * time.Millisecond, key: "a", allowed: true, remaining: 0},
+		{after: 1500 * time.Millisecond, key: "a", allowed: true, remaining: 1},
+		// the bucket doesn't hold more than the burst
+		{after: time.Hour, key: "a", allowed: true, remaining: 2},
+	}
+	for i, test := range tests {
+		allowed, remaining, wait := limiter.take(test.key, start.Add(test.after))
+		if allowed != test.allowed || remaining != test.remaining || wait != test.wait {
+			t.Errorf("take %d for %s at %v: got %v, %d, %v, want %v, %d, %v", i, test.key, test.after,
+				allowed, remaining, wait, test.allowed, test.remaining, test.wait)
+		}
+	}
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	tests := []struct {
+		limit RateLimit
+		burst int
+	}{
+		{limit: RateLimit{Rate: 10, Burst: 5}, burst: 5},
+		{limit: RateLimit{Rate: 2.5}, burst: 3},
+		{limit: RateLimit{Rate: 0.5}, burst: 1},
+	}
+	for _, test := range tests {
+		if burst := test.limit.burst(); burst != test.burst {
+			t.Errorf("burst of %+v: got %d, want %d", test.limit, burst, test.burst)
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		header string
+		want   string
+	}{
+		{key: "", want: "192.0.2.1"},
+		{key: RateLimitByIP, header: "alice", want: "192.0.2.1"},
+		{key: RateLimitGlobal, want: RateLimitGlobal},
+		{key: RateLimitByHeader + "X-User", header: "alice", want: "alice"},
+		{key: RateLimitByHeader + "X-User", want: "192.0.2.1"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "http://api.localhost/", nil)
+		if test.header != "" {
+			req.Header.Set("X-User", test.header)
+		}
+		limit := &RateLimit{Rate: 1, Key: test.key}
+		if key := limit.key(req); key != test.want {
+			t.Errorf("key %q with header %q: got %q, want %q", test.key, test.header, key, test.want)
+		}
+	}
+}
+
+func TestRateLimitersPruned(t *testing.T) {
+	defer table.Store(currentTable())
+
+	limit := &RateLimit{Rate: 1}
+	for _, name := range []string{"kept.localhost", "deleted.localhost", "unlimited.localhost"} {
+		b := Backend{To: Upstreams{{URL: "http://app"}}, RateLimit: limit}
+		b.setDefaults()
+		b.prepare(name)
+		if err := updateTable(OriginReload, func(backends map[string]*Backend) ([]*Change, error) {
+			backends[name] = &b
+			return []*Change{{Name: name, Action: ChangeCreate, Backend: b}}, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		getRateLimiter(name, limit)
+	}
+
+	updateTable(OriginReload, func(backends map[string]*Backend) ([]*Change, error) {
+		delete(backends, "deleted.localhost")
+		unlimited := *backends["unlimited.localhost"]
+		unlimited.RateLimit = nil
+		backends["unlimited.localhost"] = &unlimited
+		return []*Change{
+			{Name: "deleted.localhost", Action: ChangeDelete},
+			{Name: "unlimited.localhost", Action: ChangeUpdate, Backend: unlimited},
+		}, nil
+	})
+
+	rateLimitersLock.Lock()
+	defer rateLimitersLock.Unlock()
+	for name, want := range map[string]bool{"kept.localhost": true, "deleted.localhost": false, "unlimited.localhost": false} {
+		if _, ok := rateLimiters[name]; ok != want {
+			t.Errorf("limiter of %s kept: %v, want %v", name, ok, want)
+		}
+	}
+	delete(rateLimiters, "kept.localhost")
+}
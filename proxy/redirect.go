@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// redirectPlaceholders are the placeholders that can be used in the redirect targets, with the captures of the host.
+var redirectPlaceholders = []string{"host", "path", "scheme"}
+
+// Redirect sends a redirection to the client for the paths matching the pattern.
+type Redirect struct {
+	// Pattern is the regular expression to match the path, all the paths match if it is empty.
+	Pattern string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+
+	// To is the target of the redirection. It can use the groups of the pattern ($1, ${name}), the captures of the host,
+	// and the {host}, {path} and {scheme} placeholders.
+	To string `yaml:"to" json:"to"`
+
+	// Status is the redirection status: 301, 302 (default), 303, 307 or 308.
+	Status int `yaml:"status,omitempty" json:"status,omitempty"`
+
+	// PreserveQuery adds the query string of the request to the target, true by default.
+	PreserveQuery *bool `yaml:"preserve_query,omitempty" json:"preserve_query,omitempty"`
+
+	// compiled Pattern
+	re *regexp.Regexp
+}
+
+// validate checks and compiles the redirection, captures are the placeholders that can be used in the target.
+func (r *Redirect) validate(field string, captures []string) ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if r.To == "" {
+		errs = append(errs, &ConfigError{Field: field, Message: "redirect target is empty"})
+	}
+	switch r.Status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		errs = append(errs, &ConfigError{
+			Field:   field + ".status",
+			Message: fmt.Sprintf("invalid redirect status %d, use 301, 302, 303, 307 or 308", r.Status),
+		})
+	}
+	for _, name := range targetPlaceholders(r.To) {
+		if !contains(captures, name) && !contains(redirectPlaceholders, name) {
+			errs = append(errs, &ConfigError{Field: field + ".to", Message: "unknown placeholder {" + name + "} in " + r.To})
+		}
+	}
+
+	pattern := r.Pattern
+	if pattern == "" {
+		pattern = ".*"
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		errs = append(errs, &ConfigError{Field: field + ".pattern", Message: err.Error()})
+	}
+	r.re = re
+	return errs
+}
+
+// target returns the redirection target for the request, false if the path doesn't match.
+func (r *Redirect) target(req *http.Request) (string, bool) {
+	if r.re == nil {
+		return "", false
+	}
+	path := req.URL.Path
+	match := r.re.FindStringSubmatchIndex(path)
+	if match == nil {
+		return "", false
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	target := string(r.re.ExpandString(nil, r.To, path, match))
+	target = substituteTarget(target, map[string]string{
+		"host":   req.Host,
+		"path":   path,
+		"scheme": scheme,
+	})
+	if (r.PreserveQuery == nil || *r.PreserveQuery) && req.URL.RawQuery != "" {
+		if strings.Contains(target, "?") {
+			target += "&" + req.URL.RawQuery
+		} else {
+			target += "?" + req.URL.RawQuery
+		}
+	}
+	return target, true
+}
+
+// targetPlaceholders returns the placeholders of a redirect target, the "${name}" groups of the pattern are not
+// placeholders.
+func targetPlaceholders(to string) []string {
+	return placeholders(strings.Replace(to, "${", "$", -1))
+}
+
+// substituteTarget replaces the placeholders of a redirect target, the "${name}" groups of the pattern are kept.
+func substituteTarget(to string, values map[string]string) string {
+	protected := strings.Replace(to, "${", "\x00", -1)
+	return strings.Replace(substitute(protected, values), "\x00", "${", -1)
+}
+
+// redirect sends the redirection of the first matching rule of the backend, it returns false if no rule matches.
+func (b *Backend) redirect(rw http.ResponseWriter, req *http.Request) bool {
+	for _, r := range b.Redirects {
+		target, ok := r.target(req)
+		if !ok {
+			continue
+		}
+		status := r.Status
+		if status == 0 {
+			status = http.StatusFound
+		}
+		http.Redirect(rw, req, target, status)
+		return true
+	}
+	return false
+}
+
+// redirectToHTTPS sends the client to the same url with https, on the configured https port. The method and the body are
+// kept for the requests that are not GET or HEAD.
+func redirectToHTTPS(rw http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		// IPv6 address without port, like "[::1]"
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	if port := httpsPort(); port != 0 && port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	status := http.StatusMovedPermanently
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(rw, req, "https://"+host+req.URL.RequestURI(), status)
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	defer func(started *listeningEntrypoint) {
+		listeningLock.Lock()
+		defer listeningLock.Unlock()
+		if started == nil {
+			delete(listening, EntrypointHTTPS)
+		} else {
+			listening[EntrypointHTTPS] = started
+		}
+	}(listening[EntrypointHTTPS])
+
+	tests := []struct {
+		host     string
+		port     int
+		location string
+	}{
+		{host: "app.localhost", port: 443, location: "https://app.localhost/x?a=1"},
+		{host: "app.localhost:8080", port: 443, location: "https://app.localhost/x?a=1"},
+		{host: "app.localhost", port: 8443, location: "https://app.localhost:8443/x?a=1"},
+		{host: "[::1]", port: 443, location: "https://[::1]/x?a=1"},
+		{host: "[::1]:8080", port: 443, location: "https://[::1]/x?a=1"},
+		{host: "[::1]", port: 8443, location: "https://[::1]:8443/x?a=1"},
+		{host: "[::1]:8080", port: 10443, location: "https://[::1]:10443/x?a=1"},
+	}
+	for _, test := range tests {
+		SetEntrypointAddress(EntrypointHTTPS, ":"+strconv.Itoa(test.port), true)
+		req := httptest.NewRequest("GET", "http://app.localhost/x?a=1", nil)
+		req.Host = test.host
+		rw := httptest.NewRecorder()
+		redirectToHTTPS(rw, req)
+		if location := rw.Header().Get("Location"); location != test.location {
+			t.Errorf("%s with port %d: redirected to %s, want %s", test.host, test.port, location, test.location)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// WatchConfigFile reloads the configuration when the given file changes (checked every "interval", polling is disabled if
+// interval is not positive) or when the process receives SIGHUP. A broken file is rejected and the running configuration
+// is kept. This function never returns.
+func WatchConfigFile(path string, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		log.Printf("Watching configuration file %s every %s", path, interval)
+	}
+
+	modTime, size := fileVersion(path)
+	for {
+		select {
+		case <-hup:
+			log.Println("SIGHUP received, reloading", path)
+		case <-tick:
+			newModTime, newSize := fileVersion(path)
+			if newModTime.Equal(modTime) && newSize == size {
+				continue
+			}
+			modTime, size = newModTime, newSize
+			log.Println("Configuration file changed, reloading", path)
+		}
+		reloadConfigFile(path)
+	}
+}
+
+// reloadConfigFile reads and applies the configuration file, errors are logged.
+func reloadConfigFile(path string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println("Configuration reload failed:", err)
+		return
+	}
+	if err := ReloadConfig(string(content)); err != nil {
+		log.Println("Configuration reload failed, keeping the current configuration:", err)
+	}
+}
+
+// fileVersion returns the modification time and the size of the file, zero values if the file cannot be read.
+func fileVersion(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry conditions, the other ones are status codes or ranges.
+const (
+	RetryOnError   = "error"
+	RetryOnTimeout = "timeout"
+)
+
+// Retry defaults.
+const (
+	defaultRetryAttempts = 2
+	defaultRetryBackoff  = 100 * time.Millisecond
+	defaultRetryMaxBody  = 64 * 1024
+)
+
+// RetriesHeader is the response header giving the number of retries made for the request.
+const RetriesHeader = "X-Pathwae-Retries"
+
+// idempotentMethods can be sent twice without side effect.
+var idempotentMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete,
+}
+
+// Retry sends again the failed requests, to the next upstream (the same one if there is only one). It helps while a
+// container restarts.
+type Retry struct {
+	// Attempts is the number of retries after the first try, 2 by default.
+	Attempts int `yaml:"attempts,omitempty" json:"attempts,omitempty"`
+
+	// Backoff is the time to wait before the first retry, doubled for each retry, 100ms by default.
+	Backoff Duration `yaml:"backoff,omitempty" json:"backoff,omitempty"`
+
+	// On are the failures to retry: "error" when the upstream can't be reached, "timeout", or the response status codes
+	// like "503", "5xx" or "502-504". Only "error" by default.
+	On []string `yaml:"on,omitempty" json:"on,omitempty"`
+
+	// NonIdempotent retries the POST and PATCH requests too, they could be processed twice.
+	NonIdempotent bool `yaml:"non_idempotent,omitempty" json:"non_idempotent,omitempty"`
+
+	// MaxBody is the size, in bytes, of the request bodies kept to be sent again, 64KiB by default. The requests with a
+	// bigger body are not retried.
+	MaxBody int64 `yaml:"max_body,omitempty" json:"max_body,omitempty"`
+}
+
+// validate checks the retry settings.
+func (r *Retry) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if r == nil {
+		return errs
+	}
+	if r.Attempts < 0 {
+		errs = append(errs, &ConfigError{Field: "retry.attempts", Message: "attempts can't be negative"})
+	}
+	if r.MaxBody < 0 {
+		errs = append(errs, &ConfigError{Field: "retry.max_body", Message: "max_body can't be negative"})
+	}
+	for _, on := range r.On {
+		if on == RetryOnError || on == RetryOnTimeout {
+			continue
+		}
+		if _, _, err := parseStatusRange(on); err != nil {
+			errs = append(errs, &ConfigError{
+				Field:   "retry.on",
+				Message: fmt.Sprintf("invalid condition %q, use error, timeout or a status", on),
+			})
+		}
+	}
+	return errs
+}
+
+// attempts returns the number of retries.
+func (r *Retry) attempts() int {
+	if r.Attempts == 0 {
+		return defaultRetryAttempts
+	}
+	return r.Attempts
+}
+
+// conditions returns the failures to retry.
+func (r *Retry) conditions() []string {
+	if len(r.On) == 0 {
+		return []string{RetryOnError}
+	}
+	return r.On
+}
+
+// retriesError returns true if the transport error must be retried.
+func (r *Retry) retriesError(err error) bool {
+	if isTimeout(err) {
+		return contains(r.conditions(), RetryOnTimeout)
+	}
+	return contains(r.conditions(), RetryOnError)
+}
+
+// retriesStatus returns true if the response status must be retried.
+func (r *Retry) retriesStatus(status int) bool {
+	for _, on := range r.conditions() {
+		if first, last, err := parseStatusRange(on); err == nil && status >= first && status <= last {
+			return true
+		}
+	}
+	return false
+}
+
+// retryTransport sends the request again to the next upstreams when it fails. It is created for each request.
+type retryTransport struct {
+	retry     *Retry
+	transport http.RoundTripper
+	upstreams *pool
+
+	// retries is the number of retries made, last is the upstream of the last retry
+	retries int
+	last    *poolUpstream
+
+	// release frees the upstreams used by the retries
+	release []func()
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.replayable(req) {
+		return t.transport.RoundTrip(req)
+	}
+
+	for {
+		resp, err := t.transport.RoundTrip(req)
+		if t.retries >= t.retry.attempts() || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err == nil && !t.retry.retriesStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if err != nil && !t.retry.retriesError(err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, defaultRetryMaxBody))
+			resp.Body.Close()
+		}
+
+		// wait, then try the next upstream
+		backoff := t.retry.Backoff.or(defaultRetryBackoff) << uint(t.retries)
+		if err := sleep(req.Context(), backoff); err != nil {
+			return nil, err
+		}
+		t.retries++
+		if req, err = t.next(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// replayable returns true if the request can be sent again, its body is then kept in memory.
+func (t *retryTransport) replayable(req *http.Request) bool {
+	if !t.retry.NonIdempotent && !contains(idempotentMethods, req.Method) {
+		return false
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+
+	maxBody := t.retry.MaxBody
+	if maxBody == 0 {
+		maxBody = defaultRetryMaxBody
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBody+1))
+	if err != nil || int64(len(body)) > maxBody {
+		// too big, or broken: send what was read followed by the rest
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return false
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return true
+}
+
+// next returns a copy of the request sent to the next upstream.
+func (t *retryTransport) next(req *http.Request) (*http.Request, error) {
+	retried := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retried.Body = body
+	}
+	upstream := t.upstreams.next()
+	if upstream == nil {
+		return retried, nil
+	}
+	to, err := parseURL(upstream.URL)
+	if err != nil || isFileURL(to) {
+		// the request is sent again to the previous upstream, this one can be tried by another request
+		upstream.state.circuit.abort()
+		return retried, nil
+	}
+	upstream.state.acquire()
+	t.release = append(t.release, upstream.state.release)
+	t.last = upstream
+	retried = withUpstream(retried, upstream)
+	retried.URL.Scheme, retried.URL.Host = to.Scheme, to.Host
+	return retried, nil
+}
+
+// releaseUpstreams frees the upstreams used by the retries, once the response is sent.
+func (t *retryTransport) releaseUpstreams() {
+	for _, release := range t.release {
+		release()
+	}
+	t.release = nil
+}
+
+// lastUpstream returns the upstream of the last attempt, the first one if there was no retry to another upstream. t can
+// be nil.
+func (t *retryTransport) lastUpstream(first *poolUpstream) *poolUpstream {
+	if t == nil || t.last == nil {
+		return first
+	}
+	return t.last
+}
+
+// setRetriesHeader tells the client how many retries were made, t can be nil.
+func (t *retryTransport) setRetriesHeader(header http.Header) {
+	if t != nil && t.retries > 0 {
+		header.Set(RetriesHeader, strconv.Itoa(t.retries))
+	}
+}
+
+// sleep waits for the duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is a transport answering with a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryTransport(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		retry   Retry
+		method  string
+		body    string
+		results []int // status of each attempt, 0 for an error
+		hosts   string
+		retries int
+	}{
+		{name: "success", method: "GET", results: []int{200}, hosts: "a"},
+		{name: "error", method: "GET", results: []int{0, 200}, hosts: "a b", retries: 1},
+		{name: "attempts", method: "GET", results: []int{0, 0, 0}, hosts: "a b a", retries: 2},
+		{name: "more attempts", retry: Retry{Attempts: 3}, method: "GET", results: []int{0, 0, 0, 0}, hosts: "a b a b", retries: 3},
+		{name: "status not retried", method: "GET", results: []int{503}, hosts: "a"},
+		{name: "status", retry: Retry{On: []string{"5xx"}}, method: "GET", results: []int{503, 200}, hosts: "a b", retries: 1},
+		{name: "error not retried", retry: Retry{On: []string{"503"}}, method: "GET", results: []int{0}, hosts: "a"},
+		{name: "post", method: "POST", body: "data", results: []int{0}, hosts: "a"},
+		{name: "patch", method: "PATCH", body: "data", results: []int{0}, hosts: "a"},
+		{
+			name: "non idempotent", retry: Retry{NonIdempotent: true}, method: "POST", body: "data",
+			results: []int{0, 200}, hosts: "a b", retries: 1,
+		},
+		{name: "put body", method: "PUT", body: "data", results: []int{0, 0, 200}, hosts: "a b a", retries: 2},
+		{name: "body too big", retry: Retry{MaxBody: 3}, method: "PUT", body: "data", results: []int{0}, hosts: "a"},
+		{name: "body at max", retry: Retry{MaxBody: 4}, method: "PUT", body: "data", results: []int{0, 200}, hosts: "a b", retries: 1},
+	}
+	for _, test := range tests {
+		test.retry.Backoff = Duration(time.Millisecond)
+		hosts := make([]string, 0)
+		upstreams := newPool("app.localhost", BalanceRoundRobin, Upstreams{{URL: "http://a"}, {URL: "http://b"}}, localUpstreamStates())
+		first := upstreams.next() // the first attempt goes to "a"
+		transport := &retryTransport{
+			retry:     &test.retry,
+			upstreams: upstreams,
+			transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				hosts = append(hosts, req.URL.Host)
+				if req.Body != nil {
+					body, _ := ioutil.ReadAll(req.Body)
+					if string(body) != test.body {
+						t.Errorf("%s: attempt %d got body %q, want %q", test.name, len(hosts), body, test.body)
+					}
+				}
+				status := test.results[len(hosts)-1]
+				if status == 0 {
+					return nil, errRefused
+				}
+				return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+			}),
+		}
+
+		var req *http.Request
+		if test.body == "" {
+			req = httptest.NewRequest(test.method, "http://a/path", nil)
+		} else {
+			req = httptest.NewRequest(test.method, "http://a/path", strings.NewReader(test.body))
+		}
+		resp, err := transport.RoundTrip(req)
+		transport.releaseUpstreams()
+
+		last := test.results[len(test.results)-1]
+		if last == 0 && err == nil || last != 0 && (err != nil || resp.StatusCode != last) {
+			t.Errorf("%s: got %v %v, want status %d", test.name, resp, err, last)
+		}
+		if strings.Join(hosts, " ") != test.hosts {
+			t.Errorf("%s: sent to %v, want %s", test.name, hosts, test.hosts)
+		}
+		if transport.retries != test.retries {
+			t.Errorf("%s: %d retries, want %d", test.name, transport.retries, test.retries)
+		}
+		if last := transport.lastUpstream(first); last.URL != "http://"+hosts[len(hosts)-1] {
+			t.Errorf("%s: last upstream %s, want the one of the last attempt %s", test.name, last.URL, hosts[len(hosts)-1])
+		}
+	}
+}
+
+func TestRetryHeader(t *testing.T) {
+	header := http.Header{}
+	var transport *retryTransport
+	transport.setRetriesHeader(header)
+	(&retryTransport{}).setRetriesHeader(header)
+	if header.Get(RetriesHeader) != "" {
+		t.Errorf("retries header set without retry: %s", header.Get(RetriesHeader))
+	}
+	(&retryTransport{retries: 2}).setRetriesHeader(header)
+	if header.Get(RetriesHeader) != "2" {
+		t.Errorf("retries header is %q, want 2", header.Get(RetriesHeader))
+	}
+}
+
+// brokenReader returns its content, then an error.
+type brokenReader struct {
+	content string
+}
+
+// Read implements io.Reader.
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if r.content == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.content)
+	r.content = r.content[n:]
+	return n, nil
+}
+
+func TestRetryReplayable(t *testing.T) {
+	transport := &retryTransport{retry: &Retry{}}
+
+	req := httptest.NewRequest("PUT", "http://a/path", &brokenReader{content: "data"})
+	if transport.replayable(req) {
+		t.Error("a body that can't be read is kept to be sent again")
+	}
+	// what was read is sent, followed by the error of the body
+	if body, err := ioutil.ReadAll(req.Body); string(body) != "data" || err == nil {
+		t.Errorf("forwarded body %q with error %v", body, err)
+	}
+
+	req = httptest.NewRequest("PUT", "http://a/path", strings.NewReader("data"))
+	if !transport.replayable(req) || req.GetBody == nil {
+		t.Fatal("a small body is not kept to be sent again")
+	}
+	for i := 0; i < 2; i++ {
+		body, _ := req.GetBody()
+		if content, _ := ioutil.ReadAll(body); string(content) != "data" {
+			t.Errorf("replayed body %q, want data", content)
+		}
+	}
+
+	if transport.replayable(httptest.NewRequest("POST", "http://a/path", nil)) {
+		t.Error("a POST request is sent again without non_idempotent")
+	}
+}
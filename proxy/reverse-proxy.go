@@ -4,7 +4,6 @@ import (
 	"crypto/tls"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"strings"
 	"time"
 )
@@ -22,18 +21,18 @@ type ReverseProxy struct {
 	// http.Server Composing.
 	*http.Server
 
-	// list of configured backends to proxy to.
-	backends map[string]*Backend
+	// Entrypoint is the name of the entrypoint, the backends restricted to other entrypoints are not served.
+	Entrypoint string
 }
 
-// NewReversProxy returns a new ReverseProxy to handle HTTP requests.
-func NewReversProxy(conf, addr string) *ReverseProxy {
+// NewReversProxy returns a new ReverseProxy to handle HTTP requests. The configuration must be loaded with LoadYAMLConfig.
+func NewReversProxy(addr string) *ReverseProxy {
 	s := &ReverseProxy{
 		Server: &http.Server{
 			Addr: addr,
 		},
-		backends: LoadYAMLConfig(conf),
 	}
+	currentSettings().ServerTimeouts.apply(s.Server)
 
 	s.Server.Handler = s // force to use the ServeHTTP method
 	return s
@@ -43,48 +42,146 @@ func NewReversProxy(conf, addr string) *ReverseProxy {
 // in HTTP or HTTPS. It will proxy the request to the right server with httputil.ReverseProxy.
 func (rp *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	// Get the target host and make a new request to it.
-	target, ok := rp.backends[req.Host]
-	if !ok {
-		http.Error(rw, "no such host", http.StatusBadRequest)
+	name, target := matchBackend(req.Host, rp.Entrypoint)
+
+	// refused clients don't even know if the host exists
+	if !isAllowed(req, target) {
+		log.Printf("Access denied to %s for %s", req.Host, clientIP(req))
+		stat := newStat(req, name, "")
+		if name == "" {
+			// the hosts are chosen by the clients, they are all counted in one place
+			stat.host = UnknownHostsStats
+		}
+		stat.Status, stat.Event, stat.Client = http.StatusForbidden, "blocked", clientIP(req)
+		sendStat(stat)
+		writeError(rw, req, nil, http.StatusForbidden, "access denied")
+		return
+	}
+
+	if target == nil {
+		// the index page helps humans, unless a 404 page is configured
+		if acceptsJSON(req) || errorPageFile(nil, http.StatusNotFound) != "" {
+			writeError(rw, req, nil, http.StatusNotFound, "no such host")
+		} else {
+			serveIndex(rw, req, rp.Entrypoint)
+		}
 		return
 	}
 
 	// if backend is disabled, return a 503
 	if !*target.Enabled {
-		http.Error(rw, "backend is disabled", http.StatusServiceUnavailable)
+		writeError(rw, req, target, http.StatusServiceUnavailable, "backend is disabled")
+		return
+	}
+
+	// maintenance page, unless the request bypasses it
+	if target.underMaintenance(rw, req) {
+		stat := newStat(req, name, "")
+		stat.Status, stat.Event = http.StatusServiceUnavailable, "maintenance"
+		sendStat(stat)
 		return
 	}
 
 	// if req.TLS is nil and server.ForceSSL, redirect
 	if target.ForceSSL && req.TLS == nil {
-		http.Redirect(
-			rw, req,
-			"https://"+req.Host+req.URL.String(),
-			http.StatusMovedPermanently,
-		)
+		redirectToHTTPS(rw, req)
 		return
 	}
 
-	// fix the url if no scheme is provided
-	if !strings.HasPrefix(target.To, "http") {
-		target.To = "http://" + target.To
+	// too many requests, before spending time on the password
+	if !target.limit(name, rw, req) {
+		stat := newStat(req, name, "")
+		stat.Status, stat.Event, stat.Client = http.StatusTooManyRequests, "rate_limited", clientIP(req)
+		sendStat(stat)
+		writeError(rw, req, target, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+
+	// the user is checked before anything else is sent
+	if target.BasicAuth != nil && !target.BasicAuth.authenticate(req) {
+		target.BasicAuth.challenge(rw)
+		writeError(rw, req, target, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	// redirections are sent before looking for an upstream
+	if target.redirect(rw, req) {
+		return
+	}
+
+	// find the route to use for this path, and the upstream to use
+	upstreams := target.pool
+	routeName := ""
+	route := target.Route(req.URL.Path)
+	if route != nil {
+		upstreams = route.pool
+		routeName = route.Path
+	}
+	rewrite := target.pathRewrite(route)
+	upstream := upstreams.next()
+	if upstream == nil && upstreams.isEmpty() {
+		writeError(rw, req, target, http.StatusNotFound, "no route for this path")
+		return
+	}
+	if upstream == nil {
+		// all the circuits are open, don't make the client wait
+		stat := newStat(req, name, routeName)
+		stat.Status, stat.Event = http.StatusServiceUnavailable, "circuit_open"
+		sendStat(stat)
+		writeError(rw, req, target, http.StatusServiceUnavailable, "no upstream available")
+		return
 	}
+	upstream.state.acquire()
+	defer upstream.state.release()
 
 	// we must proxy the "targe" host to "to" host
-	to, err := url.Parse(target.To)
+	to, err := parseURL(upstream.URL)
 	if err != nil {
-		http.Error(rw, "url parse: "+err.Error(), http.StatusBadRequest)
+		// nothing is sent, the upstream can be tried by another request
+		upstream.state.circuit.abort()
+		writeError(rw, req, target, http.StatusBadGateway, "url parse: "+err.Error())
 		return
 	}
 
 	// make seom stats
-	go func(r *http.Request) {
-		// send the content to stats, not the pointer
-		StatChan <- *r
-	}(req)
+	sendStat(newStat(req, name, routeName))
+
+	// the request id is sent to the upstream, and given back to the client
+	values := headerValues(req)
+
+	// file upstreams are served from the directory, there is nothing to proxy
+	if isFileURL(to) {
+		// the files are not counted by the circuit breaker, a half open circuit must not wait for their result
+		upstream.state.circuit.abort()
+		rw.Header().Set("X-Request-ID", values["request_id"])
+		target.SecurityHeaders.apply(rw.Header(), req)
+		target.ResponseHeaders.apply(rw.Header(), values)
+		requested := *req.URL
+		rewrite.apply(&requested)
+		serveStatic(rw, req, target, to.Path, &requested)
+		return
+	}
+
+	// the total timeout covers the whole exchange with the upstream
+	req, cancel := target.withTimeout(req)
+	defer cancel()
+	req = withUpstream(req, upstream)
+
+	// the failures are counted by upstream, and failed requests can be sent again to the next upstream
+	var transport http.RoundTripper = getTransport(name, target.Timeouts)
+	if target.CircuitBreaker != nil {
+		transport = &circuitTransport{backend: name, breaker: target.CircuitBreaker, transport: transport}
+	}
+	var retry *retryTransport
+	if target.Retry != nil {
+		retry = &retryTransport{retry: target.Retry, transport: transport, upstreams: upstreams}
+		transport = retry
+		defer retry.releaseUpstreams()
+	}
 
 	// create a ReverseProxy
 	proxy := &httputil.ReverseProxy{
+		Transport: transport,
 		Director: func(proxied *http.Request) {
 			proxied.URL.Scheme = to.Scheme
 			proxied.URL.Host = to.Host
@@ -93,16 +190,55 @@ func (rp *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 				host = strings.Split(host, ":")[0]
 			}
 			proxied.Header.Set("Host", host)
-			proxied.Header.Set("X-Forwarded-For", req.RemoteAddr)
+			// the address of the client is added by httputil.ReverseProxy, the previous ones are kept only if they
+			// are given by a trusted proxy
+			if !fromTrustedProxy(req) {
+				proxied.Header.Del("X-Forwarded-For")
+			}
 			proxied.Header.Set("X-Forwarded-Host", req.Host)
 			if req.TLS != nil {
 				proxied.Header.Set("X-Forwarded-Proto", "https")
 			} else {
 				proxied.Header.Set("X-Forwarded-Proto", "http")
 			}
+			if rewrite.StripPrefix != "" && stripPrefix(req.URL.Path, rewrite.StripPrefix) != req.URL.Path {
+				proxied.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(rewrite.StripPrefix, "/"))
+			}
+			rewrite.apply(proxied.URL)
+			target.RequestHeaders.apply(proxied.Header, values)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Set("X-Request-ID", values["request_id"])
+			retry.setRetriesHeader(resp.Header)
+			if location := resp.Header.Get("Location"); location != "" {
+				// the upstream can be another one than the chosen one after a retry
+				resp.Header.Set("Location", rewrite.publicLocation(location, resp.Request.URL, req))
+			}
+			target.SecurityHeaders.apply(resp.Header, req)
+			target.ResponseHeaders.apply(resp.Header, values)
+			return nil
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			failed := retry.lastUpstream(upstream)
+			log.Printf("Proxy error for %s to %s: %v", name, failed.URL, err)
+			retry.setRetriesHeader(rw.Header())
+			if isTimeout(err) {
+				stat := newStat(req, name, routeName)
+				stat.Status, stat.Event, stat.Upstream = http.StatusGatewayTimeout, "timeout", failed.URL
+				sendStat(stat)
+				writeError(rw, req, target, http.StatusGatewayTimeout, "upstream timed out")
+				return
+			}
+			writeError(rw, req, target, http.StatusBadGateway, "upstream is not reachable")
 		},
 	}
 	proxy.ServeHTTP(rw, req)
+
+	if retry != nil && retry.retries > 0 {
+		stat := newStat(req, name, routeName)
+		stat.Event, stat.Retries = "retried", retry.retries
+		sendStat(stat)
+	}
 }
 
 // GetCerts is a callback function for tls.Config.GetCertificate - it search the right certificate to use.
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// PathRewrite changes the path sent to the upstream. The prefix is stripped, then the first matching rewrite rule is
+// applied, then the prefix is added. It can be set on a backend and on a route, the rules of the route win.
+type PathRewrite struct {
+	// StripPrefix is removed from the path, e.g. "/grafana" sends "/grafana/login" as "/login".
+	StripPrefix string `yaml:"strip_prefix,omitempty" json:"strip_prefix,omitempty"`
+
+	// AddPrefix is added to the path, e.g. "/app" sends "/login" as "/app/login".
+	AddPrefix string `yaml:"add_prefix,omitempty" json:"add_prefix,omitempty"`
+
+	// Rewrite are regular expressions replacements of the path, only the first matching rule is applied.
+	Rewrite []*RewriteRule `yaml:"rewrite,omitempty" json:"rewrite,omitempty"`
+}
+
+// RewriteRule replaces the path matching a regular expression.
+type RewriteRule struct {
+	// Pattern is the regular expression to match.
+	Pattern string `yaml:"pattern" json:"pattern"`
+
+	// To is the new path, it can use the groups of the pattern ($1, ${name}).
+	To string `yaml:"to" json:"to"`
+
+	// compiled Pattern
+	re *regexp.Regexp
+}
+
+// isSet returns true if the path is changed.
+func (p *PathRewrite) isSet() bool {
+	return p.StripPrefix != "" || p.AddPrefix != "" || len(p.Rewrite) > 0
+}
+
+// validate checks and compiles the rewrite rules.
+func (p *PathRewrite) validate(field string) ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if p.StripPrefix != "" && !strings.HasPrefix(p.StripPrefix, "/") {
+		errs = append(errs, &ConfigError{Field: field + "strip_prefix", Message: "prefix must start with /"})
+	}
+	if p.AddPrefix != "" && !strings.HasPrefix(p.AddPrefix, "/") {
+		errs = append(errs, &ConfigError{Field: field + "add_prefix", Message: "prefix must start with /"})
+	}
+	for _, rule := range p.Rewrite {
+		if rule == nil || rule.Pattern == "" {
+			errs = append(errs, &ConfigError{Field: field + "rewrite", Message: "rewrite pattern is empty"})
+			continue
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			errs = append(errs, &ConfigError{Field: field + "rewrite", Message: err.Error()})
+			continue
+		}
+		rule.re = re
+	}
+	return errs
+}
+
+// apply changes the path of the url sent to the upstream.
+func (p *PathRewrite) apply(u *url.URL) {
+	path, raw := u.Path, u.RawPath
+	if p.StripPrefix != "" {
+		path = stripPrefix(path, p.StripPrefix)
+		if raw != "" {
+			raw = stripPrefix(raw, p.StripPrefix)
+		}
+	}
+	for _, rule := range p.Rewrite {
+		if rule.re != nil && rule.re.MatchString(path) {
+			// the escaped path can't follow a regular expression
+			path, raw = rule.re.ReplaceAllString(path, rule.To), ""
+			if !strings.HasPrefix(path, "/") {
+				// the upstreams need an absolute path
+				path = "/" + path
+			}
+			break
+		}
+	}
+	if p.AddPrefix != "" {
+		path = joinPath(p.AddPrefix, path)
+		if raw != "" {
+			raw = joinPath(p.AddPrefix, raw)
+		}
+	}
+	u.Path, u.RawPath = path, raw
+}
+
+// publicLocation returns the Location header of an upstream redirect as seen by the client: the added prefix is replaced
+// by the stripped one. Urls to other hosts are not changed.
+func (p *PathRewrite) publicLocation(location string, upstream *url.URL, req *http.Request) string {
+	if p.StripPrefix == "" && p.AddPrefix == "" {
+		return location
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	if u.Host != "" && u.Host != upstream.Host && u.Host != req.Host {
+		return location
+	}
+	if u.Host == "" && !strings.HasPrefix(u.Path, "/") {
+		// relative to the current path, nothing to change
+		return location
+	}
+
+	path := u.Path
+	if p.AddPrefix != "" {
+		if path != p.AddPrefix && !strings.HasPrefix(path, strings.TrimSuffix(p.AddPrefix, "/")+"/") {
+			return location
+		}
+		path = stripPrefix(path, p.AddPrefix)
+	}
+	if p.StripPrefix != "" {
+		if p.AddPrefix == "" && stripPrefix(path, p.StripPrefix) != path {
+			// the upstream already knows its public prefix
+			return location
+		}
+		path = joinPath(p.StripPrefix, path)
+	}
+	u.Path, u.RawPath = path, ""
+	if u.Host != "" {
+		u.Host = req.Host
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	return u.String()
+}
+
+// stripPrefix removes the prefix of the path, only if it is a whole segment. The result starts with a slash.
+func stripPrefix(path, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+		return path
+	}
+	path = strings.TrimPrefix(path, prefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// joinPath adds the prefix to the path, with only one slash between them.
+func joinPath(prefix, path string) string {
+	if path == "" || path == "/" {
+		if strings.HasSuffix(prefix, "/") {
+			return prefix
+		}
+		return prefix + path
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// pathRewrite returns the path rewriting to use, the route one if it is set.
+func (b *Backend) pathRewrite(route *Route) *PathRewrite {
+	if route != nil && route.PathRewrite.isSet() {
+		return &route.PathRewrite
+	}
+	return &b.PathRewrite
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPathRewriteApply(t *testing.T) {
+	tests := []struct {
+		rewrite PathRewrite
+		path    string
+		want    string
+	}{
+		{rewrite: PathRewrite{StripPrefix: "/grafana"}, path: "/grafana/login", want: "/login"},
+		{rewrite: PathRewrite{StripPrefix: "/grafana"}, path: "/grafana", want: "/"},
+		{rewrite: PathRewrite{StripPrefix: "/grafana"}, path: "/grafana/", want: "/"},
+		{rewrite: PathRewrite{StripPrefix: "/grafana"}, path: "/grafanas/login", want: "/grafanas/login"},
+		{rewrite: PathRewrite{StripPrefix: "/grafana/"}, path: "/grafana/login", want: "/login"},
+		{rewrite: PathRewrite{StripPrefix: "/grafana"}, path: "/grafana/a%2Fb", want: "/a%2Fb"},
+		{rewrite: PathRewrite{AddPrefix: "/app"}, path: "/login", want: "/app/login"},
+		{rewrite: PathRewrite{AddPrefix: "/app"}, path: "/", want: "/app/"},
+		{rewrite: PathRewrite{AddPrefix: "/app/"}, path: "/login/", want: "/app/login/"},
+		{rewrite: PathRewrite{StripPrefix: "/api", AddPrefix: "/v2"}, path: "/api/users", want: "/v2/users"},
+		{rewrite: PathRewrite{StripPrefix: "/api", AddPrefix: "/v2"}, path: "/api", want: "/v2/"},
+		{
+			rewrite: PathRewrite{Rewrite: []*RewriteRule{{Pattern: `^/old/(.*)$`, To: "/new/$1"}, {Pattern: "^/old", To: "/never"}}},
+			path:    "/old/page",
+			want:    "/new/page",
+		},
+		{rewrite: PathRewrite{Rewrite: []*RewriteRule{{Pattern: "^/home$", To: ""}}}, path: "/home", want: "/"},
+		{rewrite: PathRewrite{Rewrite: []*RewriteRule{{Pattern: "^/home$", To: "index.html"}}}, path: "/home", want: "/index.html"},
+		{
+			rewrite: PathRewrite{StripPrefix: "/shop", AddPrefix: "/store", Rewrite: []*RewriteRule{{Pattern: "^/cart$", To: "/basket"}}},
+			path:    "/shop/cart",
+			want:    "/store/basket",
+		},
+	}
+
+	for _, test := range tests {
+		if errs := test.rewrite.validate(""); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		u, err := url.Parse("http://upstream" + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.rewrite.apply(u)
+		if got := u.EscapedPath(); got != test.want {
+			t.Errorf("%+v on %s: got %s, want %s", test.rewrite, test.path, got, test.want)
+		}
+	}
+}
+
+func TestPathRewritePublicLocation(t *testing.T) {
+	strip := PathRewrite{StripPrefix: "/grafana"}
+	tests := []struct {
+		rewrite  PathRewrite
+		location string
+		url      string // of the client request
+		want     string
+	}{
+		{rewrite: strip, location: "/login", want: "/grafana/login"},
+		{rewrite: strip, location: "/", want: "/grafana/"},
+		{rewrite: strip, location: "/login?next=%2F", want: "/grafana/login?next=%2F"},
+		{rewrite: strip, location: "/grafana/login", want: "/grafana/login"},
+		{rewrite: strip, location: "login", want: "login"},
+		{rewrite: strip, location: "http://upstream:3000/login", want: "http://example.com/grafana/login"},
+		{rewrite: strip, location: "http://upstream:3000/login", url: "https://example.com/grafana/", want: "https://example.com/grafana/login"},
+		{rewrite: strip, location: "https://example.com/login", want: "http://example.com/grafana/login"},
+		{rewrite: strip, location: "https://accounts.example.org/login", want: "https://accounts.example.org/login"},
+		{rewrite: PathRewrite{AddPrefix: "/app"}, location: "/app/login", want: "/login"},
+		{rewrite: PathRewrite{AddPrefix: "/app"}, location: "/app", want: "/"},
+		{rewrite: PathRewrite{AddPrefix: "/app/"}, location: "/app/login", want: "/login"},
+		{rewrite: PathRewrite{AddPrefix: "/app"}, location: "/application", want: "/application"},
+		{rewrite: PathRewrite{StripPrefix: "/api", AddPrefix: "/v2"}, location: "/v2/users?page=2", want: "/api/users?page=2"},
+		{rewrite: PathRewrite{}, location: "/login", want: "/login"},
+	}
+
+	upstream, _ := url.Parse("http://upstream:3000/")
+	for _, test := range tests {
+		if test.url == "" {
+			test.url = "http://example.com/grafana/"
+		}
+		req := httptest.NewRequest("GET", test.url, nil)
+		if got := test.rewrite.publicLocation(test.location, upstream, req); got != test.want {
+			t.Errorf("%+v with %s: got %s, want %s", test.rewrite, test.location, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxResolvedHosts limits the number of hosts kept in the cache of a router.
+const maxResolvedHosts = 1024
+
+var (
+	// placeholder matches the "{name}" captures in the urls of wildcard and regex backends.
+	placeholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+)
+
+// router finds the backend to use for a requested host. Exact names win, then the longest wildcard ("*.app.localhost"),
+// then the regular expressions ("~^(?P<svc>[a-z]+)\.dev\.localhost$") in the declared order.
+type router struct {
+	resolvedCount int64 // first field to be aligned for atomic operations
+
+	exact     map[string]*hostPattern
+	wildcards []*hostPattern
+	regexps   []*hostPattern
+
+	// resolved backends for wildcard and regex hosts (*resolvedHost by host), to keep the upstreams state between
+	// requests
+	resolved sync.Map
+}
+
+// hostPattern is a wildcard or regex backend.
+type hostPattern struct {
+	name    string
+	suffix  string         // for wildcards, the domain after "*"
+	re      *regexp.Regexp // for regular expressions
+	backend *Backend
+}
+
+// resolvedHost is the backend to use for a host matched by a pattern.
+type resolvedHost struct {
+	name    string
+	backend *Backend
+}
+
+// newRouter creates the router for the given backends.
+func newRouter(backends map[string]*Backend) *router {
+	r := &router{
+		exact: make(map[string]*hostPattern),
+	}
+	for name, b := range backends {
+		switch {
+		case isRegexHost(name):
+			re, err := compileHost(name)
+			if err != nil {
+				log.Printf("Backend %s: %v", name, err)
+				continue
+			}
+			r.regexps = append(r.regexps, &hostPattern{name: name, re: re, backend: b})
+		case isWildcardHost(name):
+			r.wildcards = append(r.wildcards, &hostPattern{name: name, suffix: name[1:], backend: b})
+		default:
+			r.exact[strings.ToLower(name)] = &hostPattern{name: name, backend: b}
+		}
+	}
+
+	// longest wildcard first, the name is only used to have a stable order
+	sort.Slice(r.wildcards, func(i, j int) bool {
+		if len(r.wildcards[i].suffix) != len(r.wildcards[j].suffix) {
+			return len(r.wildcards[i].suffix) > len(r.wildcards[j].suffix)
+		}
+		return r.wildcards[i].name < r.wildcards[j].name
+	})
+	// declared order
+	sort.Slice(r.regexps, func(i, j int) bool {
+		if r.regexps[i].backend.order != r.regexps[j].backend.order {
+			return r.regexps[i].backend.order < r.regexps[j].backend.order
+		}
+		return r.regexps[i].name < r.regexps[j].name
+	})
+	return r
+}
+
+// match returns the backend name and the backend to use for the requested host. The "default" backend is returned if no
+// backend matches, nil if there is none.
+func (r *router) match(requested string) (string, *Backend) {
+	host := strings.ToLower(requested)
+	if exact, ok := r.exact[host]; ok {
+		return exact.name, exact.backend
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if exact, ok := r.exact[host]; ok {
+		return exact.name, exact.backend
+	}
+
+	if resolved, ok := r.resolved.Load(host); ok {
+		return resolved.(*resolvedHost).name, resolved.(*resolvedHost).backend
+	}
+
+	var found *hostPattern
+	var captures map[string]string
+	for _, w := range r.wildcards {
+		if strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+			found = w
+			captures = map[string]string{
+				"0": host,
+				"1": strings.TrimSuffix(host, w.suffix),
+			}
+			break
+		}
+	}
+	if found == nil {
+		for _, re := range r.regexps {
+			if matches := re.re.FindStringSubmatch(host); matches != nil {
+				found = re
+				captures = make(map[string]string, len(matches))
+				for i, name := range re.re.SubexpNames() {
+					captures[strconv.Itoa(i)] = matches[i]
+					if name != "" {
+						captures[name] = matches[i]
+					}
+				}
+				break
+			}
+		}
+	}
+	if found == nil {
+		return r.fallback()
+	}
+
+	resolved := &resolvedHost{name: found.name, backend: found.backend.resolve(found.name, captures)}
+	if atomic.LoadInt64(&r.resolvedCount) < maxResolvedHosts {
+		// concurrent requests can resolve the same host, the first stored is kept
+		if stored, loaded := r.resolved.LoadOrStore(host, resolved); loaded {
+			resolved = stored.(*resolvedHost)
+		} else {
+			atomic.AddInt64(&r.resolvedCount, 1)
+		}
+	}
+	return resolved.name, resolved.backend
+}
+
+// fallback returns the "default" backend, it receives the hosts that are not configured. The name is empty if there is
+// no default backend.
+func (r *router) fallback() (string, *Backend) {
+	if fallback, ok := r.exact[DefaultBackend]; ok {
+		return fallback.name, fallback.backend
+	}
+	return "", nil
+}
+
+// resolvedBackends returns the backends resolved from the named pattern.
+func (r *router) resolvedBackends(name string) []*Backend {
+	backends := make([]*Backend, 0)
+	r.resolved.Range(func(_, value interface{}) bool {
+		if resolved := value.(*resolvedHost); resolved.name == name {
+			backends = append(backends, resolved.backend)
+		}
+		return true
+	})
+	return backends
+}
+
+// resolve returns the backend with the captures substituted in the urls. The backend itself is returned if it has no
+// placeholder.
+func (b *Backend) resolve(name string, captures map[string]string) *Backend {
+	if !b.hasPlaceholders() {
+		return b
+	}
+	resolved := *b
+	resolved.To = substituteUpstreams(b.To, captures)
+	resolved.Backup = substituteUpstreams(b.Backup, captures)
+	resolved.Routes = make([]*Route, len(b.Routes))
+	for i, route := range b.Routes {
+		r := *route
+		r.To = substituteUpstreams(route.To, captures)
+		resolved.Routes[i] = &r
+	}
+	resolved.Redirects = make([]*Redirect, len(b.Redirects))
+	for i, redirect := range b.Redirects {
+		r := *redirect
+		r.To = substituteTarget(redirect.To, captures)
+		resolved.Redirects[i] = &r
+	}
+	// the hosts are chosen by the clients, the states of their upstreams must not stay in the global states
+	resolved.preparePools(name, localUpstreamStates())
+	return &resolved
+}
+
+// hasPlaceholders returns true if one of the urls or redirect targets of the backend has a capture placeholder.
+func (b *Backend) hasPlaceholders() bool {
+	for _, u := range append(append(Upstreams{}, b.To...), b.Backup...) {
+		if placeholder.MatchString(u.URL) {
+			return true
+		}
+	}
+	for _, route := range b.Routes {
+		for _, u := range route.To {
+			if placeholder.MatchString(u.URL) {
+				return true
+			}
+		}
+	}
+	for _, r := range b.Redirects {
+		for _, name := range targetPlaceholders(r.To) {
+			if !contains(redirectPlaceholders, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// placeholders returns the placeholders names used in the url.
+func placeholders(url string) []string {
+	names := make([]string, 0)
+	for _, match := range placeholder.FindAllStringSubmatch(url, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// substituteUpstreams replaces the placeholders of the urls by the captures.
+func substituteUpstreams(upstreams Upstreams, captures map[string]string) Upstreams {
+	substituted := make(Upstreams, len(upstreams))
+	for i, u := range upstreams {
+		substituted[i] = &Upstream{
+			URL:    substitute(u.URL, captures),
+			Weight: u.Weight,
+		}
+	}
+	return substituted
+}
+
+// substitute replaces the "{name}" placeholders by the captures, unknown placeholders are kept.
+func substitute(s string, captures map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(s, func(match string) string {
+		if value, ok := captures[match[1:len(match)-1]]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// isRegexHost returns true if the backend name is a regular expression.
+func isRegexHost(name string) bool {
+	return strings.HasPrefix(name, "~")
+}
+
+// isWildcardHost returns true if the backend name is a wildcard.
+func isWildcardHost(name string) bool {
+	return strings.HasPrefix(name, "*")
+}
+
+// compileHost compiles the regular expression of a regex backend name, the matching is case insensitive.
+func compileHost(name string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + strings.TrimPrefix(name, "~"))
+}
+
+// validateHost checks the backend name and returns the names of the captures that can be used in the urls.
+func validateHost(name string) ([]string, error) {
+	switch {
+	case name == "":
+		return nil, errors.New("host is empty")
+	case isRegexHost(name):
+		re, err := compileHost(name)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0)
+		for i, sub := range re.SubexpNames() {
+			names = append(names, strconv.Itoa(i))
+			if sub != "" {
+				names = append(names, sub)
+			}
+		}
+		return names, nil
+	case isWildcardHost(name):
+		if !strings.HasPrefix(name, "*.") || strings.Contains(name[1:], "*") {
+			return nil, errors.New("wildcard must be the first label, like *.app.localhost")
+		}
+		return []string{"0", "1"}, nil
+	case strings.ContainsAny(name, "*{}"):
+		return nil, errors.New("invalid host name")
+	}
+	return nil, nil
+}
+
+// matchBackend returns the backend name and the backend to use for the requested host on the entrypoint, nil if no
+// backend matches. The hosts restricted to other entrypoints are unknown hosts here, the default backend receives them.
+func matchBackend(host, entrypoint string) (string, *Backend) {
+	r := currentTable().router
+	name, b := r.match(host)
+	if b != nil && !b.servesEntrypoint(entrypoint) {
+		name, b = r.fallback()
+	}
+	if b != nil && !b.servesEntrypoint(entrypoint) {
+		return "", nil
+	}
+	return name, b
+}
+
+// hostName returns the requested host without the port, in lower case.
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
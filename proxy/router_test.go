@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRouterMatch(t *testing.T) {
+	backend := func(url string, order int) *Backend {
+		return &Backend{To: Upstreams{{URL: url}}, order: order}
+	}
+	r := newRouter(map[string]*Backend{
+		"app.localhost":   backend("http://app:8080", 0),
+		"*.localhost":     backend("http://generic", 1),
+		"*.api.localhost": backend("http://{1}-api", 2),
+		"*.static.example.com": {
+			To:     Upstreams{{URL: "http://static"}},
+			Routes: []*Route{{Path: "/assets", To: Upstreams{{URL: "http://{0}/assets"}}}},
+			order:  3,
+		},
+		`~^(?P<svc>[a-z]+)-(?P<env>[a-z]+)\.example\.com$`: backend("http://{svc}.{env}:3000", 4),
+		`~^(?P<name>[a-z.-]+)\.example\.com$`:              backend("http://{name}:{2}", 5),
+		DefaultBackend:                                     backend("http://fallback", 6),
+	})
+
+	tests := []struct {
+		host    string
+		name    string
+		url     string
+		routeTo string
+	}{
+		// exact names, without the port and case insensitive
+		{host: "app.localhost", name: "app.localhost", url: "http://app:8080"},
+		{host: "APP.Localhost:8001", name: "app.localhost", url: "http://app:8080"},
+		// longest wildcard
+		{host: "web.localhost", name: "*.localhost", url: "http://generic"},
+		{host: "api.localhost", name: "*.localhost", url: "http://generic"},
+		{host: "users.api.localhost", name: "*.api.localhost", url: "http://users-api"},
+		{host: "v1.users.api.localhost:8001", name: "*.api.localhost", url: "http://v1.users-api"},
+		// wildcards win over the regular expressions, the routes are resolved too
+		{
+			host: "img.static.example.com", name: "*.static.example.com", url: "http://static",
+			routeTo: "http://img.static.example.com/assets",
+		},
+		// regular expressions in the declared order, unknown placeholders are kept
+		{
+			host: "web-prod.example.com", name: `~^(?P<svc>[a-z]+)-(?P<env>[a-z]+)\.example\.com$`,
+			url: "http://web.prod:3000",
+		},
+		{host: "web.example.com", name: `~^(?P<name>[a-z.-]+)\.example\.com$`, url: "http://web:{2}"},
+		// the default backend gets the other hosts
+		{host: "unknown.org", name: DefaultBackend, url: "http://fallback"},
+		{host: "example.com", name: DefaultBackend, url: "http://fallback"},
+	}
+	for _, test := range tests {
+		name, b := r.match(test.host)
+		if name != test.name {
+			t.Errorf("%s: matched %q, want %q", test.host, name, test.name)
+			continue
+		}
+		if url := b.To[0].URL; url != test.url {
+			t.Errorf("%s: upstream %s, want %s", test.host, url, test.url)
+		}
+		if test.routeTo != "" && b.Routes[0].To[0].URL != test.routeTo {
+			t.Errorf("%s: route upstream %s, want %s", test.host, b.Routes[0].To[0].URL, test.routeTo)
+		}
+	}
+
+	// without default backend, the unknown hosts have no backend
+	r = newRouter(map[string]*Backend{"app.localhost": backend("http://app", 0)})
+	if name, b := r.match("unknown.org"); name != "" || b != nil {
+		t.Errorf("unknown.org: matched %q without default backend", name)
+	}
+}
+
+func TestResolvedUpstreamStates(t *testing.T) {
+	r := newRouter(map[string]*Backend{
+		"*.app.localhost": {To: Upstreams{{URL: "http://{1}:8080"}}},
+	})
+
+	upstreamLock.Lock()
+	count := len(upstreamStates)
+	upstreamLock.Unlock()
+	for i := 0; i < maxResolvedHosts+10; i++ {
+		_, b := r.match("host" + strconv.Itoa(i) + ".app.localhost")
+		if b == nil || b.pool == nil || len(b.pool.upstreams) != 1 {
+			t.Fatalf("host%d: backend not resolved", i)
+		}
+	}
+	upstreamLock.Lock()
+	defer upstreamLock.Unlock()
+	if len(upstreamStates) != count {
+		t.Errorf("resolved hosts added %d global upstream states", len(upstreamStates)-count)
+	}
+
+	// the cached hosts keep their state between requests
+	_, first := r.match("host1.app.localhost")
+	_, second := r.match("HOST1.app.localhost")
+	if first.pool.upstreams[0].state != second.pool.upstreams[0].state {
+		t.Error("the state of a cached host changed between requests")
+	}
+}
+
+func TestMatchBackendEntrypoint(t *testing.T) {
+	defer table.Store(currentTable())
+
+	tests := []struct {
+		defaultEntrypoints []string
+		host               string
+		entrypoint         string
+		name               string
+	}{
+		{host: "admin.localhost", entrypoint: "admin", name: "admin.localhost"},
+		{host: "app.localhost", entrypoint: "admin", name: "app.localhost"},
+		// the hosts of the other entrypoints are unknown hosts
+		{host: "admin.localhost:8001", entrypoint: EntrypointHTTP, name: DefaultBackend},
+		{host: "unknown.localhost", entrypoint: "admin", name: DefaultBackend},
+		{defaultEntrypoints: []string{EntrypointHTTPS}, host: "admin.localhost", entrypoint: EntrypointHTTP, name: ""},
+		{defaultEntrypoints: []string{EntrypointHTTPS}, host: "unknown.localhost", entrypoint: EntrypointHTTP, name: ""},
+		{defaultEntrypoints: []string{EntrypointHTTPS}, host: "admin.localhost", entrypoint: EntrypointHTTPS, name: DefaultBackend},
+	}
+	for _, test := range tests {
+		table.Store(newRoutingTable(map[string]*Backend{
+			"admin.localhost": {To: Upstreams{{URL: "http://admin"}}, Entrypoints: []string{"admin"}},
+			"app.localhost":   {To: Upstreams{{URL: "http://app"}}},
+			DefaultBackend:    {To: Upstreams{{URL: "http://fallback"}}, Entrypoints: test.defaultEntrypoints},
+		}))
+		name, b := matchBackend(test.host, test.entrypoint)
+		if name != test.name || (b == nil) != (test.name == "") {
+			t.Errorf("%s on %s with the default on %v: got %q, want %q", test.host, test.entrypoint,
+				test.defaultEntrypoints, name, test.name)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Route matching methods.
+const (
+	MatchPrefix = "prefix"
+	MatchExact  = "exact"
+	MatchRegex  = "regex"
+)
+
+// Route sends the requests matching a path to a dedicated url. Routes are declared in the "routes" list of a backend.
+type Route struct {
+	// Path is the path prefix, the exact path or the regular expression to match, depending on Match.
+	Path string `yaml:"path" json:"path"`
+
+	// Match is the matching method, "prefix" (default), "exact" or "regex".
+	Match string `yaml:"match,omitempty" json:"match,omitempty"`
+
+	// To is the url where to send the request, or a list of urls balanced with the backend strategy.
+	To Upstreams `yaml:"to" json:"to"`
+
+	// PathRewrite changes the path sent to the upstreams, instead of the rules of the backend.
+	PathRewrite `yaml:",inline"`
+
+	// compiled Path when Match is "regex"
+	re *regexp.Regexp
+
+	// upstreams selection of To
+	pool *pool
+}
+
+// compile checks the route and prepares the regular expression if needed.
+func (r *Route) compile() error {
+	if r.Path == "" {
+		return errors.New("route path is empty")
+	}
+	switch r.Match {
+	case "", MatchPrefix, MatchExact:
+	case MatchRegex:
+		re, err := regexp.Compile(r.Path)
+		if err != nil {
+			return err
+		}
+		r.re = re
+	default:
+		return errors.New("unknown route match method " + r.Match)
+	}
+	return nil
+}
+
+// matches returns true if the route matches the given path.
+func (r *Route) matches(path string) bool {
+	switch r.Match {
+	case MatchExact:
+		return path == r.Path
+	case MatchRegex:
+		return r.re != nil && r.re.MatchString(path)
+	default:
+		return hasPathPrefix(path, r.Path)
+	}
+}
+
+// hasPathPrefix returns true if the path starts with the prefix, only whole segments match: "/api" matches "/api" and
+// "/api/users", but not "/apis".
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "/" || path == prefix {
+		return true
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// compileRoutes compiles all the routes of the backend.
+func (b *Backend) compileRoutes() error {
+	for _, route := range b.Routes {
+		if err := route.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Route returns the route to use for the given path. Exact routes win, then the longest matching prefix, then the regular
+// expressions in the declared order. It returns nil if no route matches, the backend "to" should be used in this case.
+func (b *Backend) Route(path string) *Route {
+	var found *Route
+	for _, route := range b.Routes {
+		if route.Match == MatchExact && route.matches(path) {
+			return route
+		}
+	}
+	for _, route := range b.Routes {
+		if route.Match != "" && route.Match != MatchPrefix {
+			continue
+		}
+		if route.matches(path) && (found == nil || len(route.Path) > len(found.Path)) {
+			found = route
+		}
+	}
+	if found != nil {
+		return found
+	}
+	for _, route := range b.Routes {
+		if route.Match == MatchRegex && route.matches(path) {
+			return route
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Security header presets.
+const (
+	SecurityPresetNone   = "none"
+	SecurityPresetBasic  = "basic"
+	SecurityPresetStrict = "strict"
+)
+
+// noHeader is the value to not send a header of the preset.
+const noHeader = "-"
+
+// hstsPreloadMinAge is the minimum max-age accepted by the HSTS preload list.
+const hstsPreloadMinAge = 365 * 24 * time.Hour
+
+// securityPresets are the headers of each preset, the fields of the backend settings replace them.
+var securityPresets = map[string]SecurityHeaders{
+	SecurityPresetNone: {},
+	SecurityPresetBasic: {
+		HSTS:               &HSTS{MaxAge: Duration(365 * 24 * time.Hour)},
+		ContentTypeOptions: "nosniff",
+		FrameOptions:       "SAMEORIGIN",
+		ReferrerPolicy:     "strict-origin-when-cross-origin",
+	},
+	SecurityPresetStrict: {
+		HSTS:                  &HSTS{MaxAge: Duration(2 * 365 * 24 * time.Hour), IncludeSubDomains: true},
+		ContentTypeOptions:    "nosniff",
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+		ContentSecurityPolicy: "default-src 'self'; frame-ancestors 'none'; base-uri 'self'; form-action 'self'",
+		PermissionsPolicy:     "camera=(), microphone=(), geolocation=(), payment=()",
+	},
+}
+
+// SecurityHeaders adds the security headers to the responses, replacing the ones of the upstream. The headers of the
+// preset can be changed, or removed with "-".
+type SecurityHeaders struct {
+	// Preset is the base set of headers: "basic" (default), "strict" or "none".
+	Preset string `yaml:"preset,omitempty" json:"preset,omitempty"`
+
+	// HSTS is the Strict-Transport-Security header, only sent over TLS. It replaces the one of the preset, with its max-age
+	// by default.
+	HSTS *HSTS `yaml:"hsts,omitempty" json:"hsts,omitempty"`
+
+	// ContentTypeOptions is the X-Content-Type-Options header.
+	ContentTypeOptions string `yaml:"content_type_options,omitempty" json:"content_type_options,omitempty"`
+
+	// FrameOptions is the X-Frame-Options header, "DENY" or "SAMEORIGIN".
+	FrameOptions string `yaml:"frame_options,omitempty" json:"frame_options,omitempty"`
+
+	// ReferrerPolicy is the Referrer-Policy header.
+	ReferrerPolicy string `yaml:"referrer_policy,omitempty" json:"referrer_policy,omitempty"`
+
+	// ContentSecurityPolicy is the Content-Security-Policy header.
+	ContentSecurityPolicy string `yaml:"content_security_policy,omitempty" json:"content_security_policy,omitempty"`
+
+	// CSPReportOnly sends the policy in Content-Security-Policy-Report-Only: the violations are reported, not blocked.
+	CSPReportOnly bool `yaml:"csp_report_only,omitempty" json:"csp_report_only,omitempty"`
+
+	// PermissionsPolicy is the Permissions-Policy header.
+	PermissionsPolicy string `yaml:"permissions_policy,omitempty" json:"permissions_policy,omitempty"`
+}
+
+// HSTS tells the browsers to use only HTTPS for the host. It is not sent to the .localhost hosts, browsers would remember
+// it for all the local applications, unless Force is set.
+type HSTS struct {
+	// MaxAge is the time the browsers remember to use HTTPS.
+	MaxAge Duration `yaml:"max_age,omitempty" json:"max_age,omitempty"`
+
+	// IncludeSubDomains applies the policy to the subdomains.
+	IncludeSubDomains bool `yaml:"include_subdomains,omitempty" json:"include_subdomains,omitempty"`
+
+	// Preload asks to be in the browsers preload list.
+	Preload bool `yaml:"preload,omitempty" json:"preload,omitempty"`
+
+	// Force sends the header to the .localhost hosts too.
+	Force bool `yaml:"force,omitempty" json:"force,omitempty"`
+
+	// Disable doesn't send the header of the preset.
+	Disable bool `yaml:"disable,omitempty" json:"disable,omitempty"`
+}
+
+// validate checks the preset, the header values and the HSTS preload requirements.
+func (s *SecurityHeaders) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if s == nil {
+		return errs
+	}
+	if _, ok := securityPresets[s.Preset]; !ok && s.Preset != "" {
+		errs = append(errs, &ConfigError{
+			Field:   "security_headers.preset",
+			Message: "unknown preset " + s.Preset + ", use basic, strict or none",
+		})
+	}
+	switch strings.ToUpper(s.FrameOptions) {
+	case "", "DENY", "SAMEORIGIN", noHeader:
+	default:
+		errs = append(errs, &ConfigError{
+			Field:   "security_headers.frame_options",
+			Message: "invalid value " + s.FrameOptions + ", use DENY or SAMEORIGIN",
+		})
+	}
+	for field, value := range map[string]string{
+		"content_type_options":    s.ContentTypeOptions,
+		"referrer_policy":         s.ReferrerPolicy,
+		"content_security_policy": s.ContentSecurityPolicy,
+		"permissions_policy":      s.PermissionsPolicy,
+	} {
+		if strings.ContainsAny(value, "\r\n") {
+			errs = append(errs, &ConfigError{Field: "security_headers." + field, Message: "value can't have line breaks"})
+		}
+	}
+	if hsts := s.resolve().HSTS; s.HSTS != nil && s.HSTS.Preload && hsts != nil {
+		if !hsts.IncludeSubDomains || time.Duration(hsts.MaxAge) < hstsPreloadMinAge {
+			errs = append(errs, &ConfigError{
+				Field:   "security_headers.hsts.preload",
+				Message: "preload needs include_subdomains and a max_age of one year at least",
+			})
+		}
+	}
+	return errs
+}
+
+// resolve returns the headers of the preset changed by the settings.
+func (s *SecurityHeaders) resolve() SecurityHeaders {
+	preset := s.Preset
+	if preset == "" {
+		preset = SecurityPresetBasic
+	}
+	resolved := securityPresets[preset]
+	if resolved.HSTS != nil {
+		hsts := *resolved.HSTS
+		resolved.HSTS = &hsts
+	}
+
+	override := func(value *string, configured string) {
+		if configured != "" {
+			*value = configured
+		}
+	}
+	override(&resolved.ContentTypeOptions, s.ContentTypeOptions)
+	override(&resolved.FrameOptions, s.FrameOptions)
+	override(&resolved.ReferrerPolicy, s.ReferrerPolicy)
+	override(&resolved.ContentSecurityPolicy, s.ContentSecurityPolicy)
+	override(&resolved.PermissionsPolicy, s.PermissionsPolicy)
+	resolved.CSPReportOnly = s.CSPReportOnly
+
+	if s.HSTS != nil {
+		if resolved.HSTS == nil {
+			resolved.HSTS = &HSTS{}
+		}
+		if s.HSTS.MaxAge != 0 {
+			resolved.HSTS.MaxAge = s.HSTS.MaxAge
+		}
+		resolved.HSTS.IncludeSubDomains, resolved.HSTS.Preload = s.HSTS.IncludeSubDomains, s.HSTS.Preload
+		resolved.HSTS.Force = s.HSTS.Force
+		if s.HSTS.Disable || resolved.HSTS.MaxAge == 0 {
+			resolved.HSTS = nil
+		}
+	}
+	return resolved
+}
+
+// apply sets the security headers of the response to the request.
+func (s *SecurityHeaders) apply(header http.Header, req *http.Request) {
+	if s == nil {
+		return
+	}
+	resolved := s.resolve()
+	set := func(name, value string) {
+		switch value {
+		case "":
+		case noHeader:
+			header.Del(name)
+		default:
+			header.Set(name, value)
+		}
+	}
+	set("X-Content-Type-Options", resolved.ContentTypeOptions)
+	set("X-Frame-Options", resolved.FrameOptions)
+	set("Referrer-Policy", resolved.ReferrerPolicy)
+	set("Permissions-Policy", resolved.PermissionsPolicy)
+	if resolved.CSPReportOnly {
+		set("Content-Security-Policy-Report-Only", resolved.ContentSecurityPolicy)
+	} else {
+		set("Content-Security-Policy", resolved.ContentSecurityPolicy)
+	}
+
+	// HSTS over plain HTTP is ignored by the browsers, and would stick to all the local hosts
+	if hsts := resolved.HSTS; hsts != nil && req.TLS != nil && (hsts.Force || !isLocalhost(req.Host)) {
+		value := "max-age=" + strconv.FormatInt(int64(time.Duration(hsts.MaxAge).Seconds()), 10)
+		if hsts.IncludeSubDomains {
+			value += "; includeSubDomains"
+		}
+		if hsts.Preload {
+			value += "; preload"
+		}
+		header.Set("Strict-Transport-Security", value)
+	}
+}
+
+// isLocalhost returns true for localhost and its subdomains, with or without port.
+func isLocalhost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings SecurityHeaders
+		url      string
+		upstream http.Header
+		want     map[string]string // an empty value is a header that is not sent
+	}{
+		{
+			name: "basic preset over https",
+			url:  "https://app.example.com/",
+			want: map[string]string{
+				"Strict-Transport-Security": "max-age=31536000",
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "SAMEORIGIN",
+				"Referrer-Policy":           "strict-origin-when-cross-origin",
+				"Content-Security-Policy":   "",
+			},
+		},
+		{
+			name: "no hsts over http",
+			url:  "http://app.example.com/",
+			want: map[string]string{
+				"Strict-Transport-Security": "",
+				"X-Content-Type-Options":    "nosniff",
+			},
+		},
+		{
+			name: "no hsts on localhost",
+			url:  "https://app.localhost:8443/",
+			want: map[string]string{"Strict-Transport-Security": ""},
+		},
+		{
+			name:     "forced hsts on localhost",
+			settings: SecurityHeaders{HSTS: &HSTS{Force: true}},
+			url:      "https://app.localhost/",
+			want:     map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+		},
+		{
+			name:     "hsts settings",
+			settings: SecurityHeaders{HSTS: &HSTS{MaxAge: Duration(2 * 365 * 24 * time.Hour), IncludeSubDomains: true, Preload: true}},
+			url:      "https://app.example.com/",
+			want:     map[string]string{"Strict-Transport-Security": "max-age=63072000; includeSubDomains; preload"},
+		},
+		{
+			name:     "disabled hsts",
+			settings: SecurityHeaders{Preset: SecurityPresetStrict, HSTS: &HSTS{Disable: true}},
+			url:      "https://app.example.com/",
+			want: map[string]string{
+				"Strict-Transport-Security": "",
+				"X-Frame-Options":           "DENY",
+			},
+		},
+		{
+			name: "overrides",
+			settings: SecurityHeaders{
+				Preset:                SecurityPresetStrict,
+				FrameOptions:          "SAMEORIGIN",
+				ReferrerPolicy:        noHeader,
+				ContentSecurityPolicy: "default-src 'self'",
+				CSPReportOnly:         true,
+			},
+			url:      "https://app.example.com/",
+			upstream: http.Header{"Referrer-Policy": {"unsafe-url"}, "X-Frame-Options": {"ALLOW"}},
+			want: map[string]string{
+				"X-Frame-Options":                     "SAMEORIGIN",
+				"Referrer-Policy":                     "",
+				"Content-Security-Policy":             "",
+				"Content-Security-Policy-Report-Only": "default-src 'self'",
+				"Permissions-Policy":                  "camera=(), microphone=(), geolocation=(), payment=()",
+			},
+		},
+		{
+			name:     "none preset keeps the upstream headers",
+			settings: SecurityHeaders{Preset: SecurityPresetNone},
+			url:      "https://app.example.com/",
+			upstream: http.Header{"X-Frame-Options": {"DENY"}},
+			want: map[string]string{
+				"Strict-Transport-Security": "",
+				"X-Frame-Options":           "DENY",
+				"X-Content-Type-Options":    "",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the https requests have a TLS connection state
+			req := httptest.NewRequest("GET", test.url, nil)
+			header := http.Header{}
+			for name, values := range test.upstream {
+				header[name] = values
+			}
+			test.settings.apply(header, req)
+			for name, want := range test.want {
+				if got := header.Get(name); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Settings are the global options, given as top level keys of the configuration. The other top level keys are the hosts.
+type Settings struct {
+	// Listeners are the addresses to listen, read only at startup.
+	Listeners Listeners `yaml:"listeners,omitempty" json:"listeners,omitempty"`
+
+	// ServerTimeouts are the timeouts of the client connections, read only at startup.
+	ServerTimeouts ServerTimeouts `yaml:"server_timeouts,omitempty" json:"server_timeouts,omitempty"`
+
+	// HTTPSPort is the port used to redirect to HTTPS the backends with force_ssl, the port of the HTTPS entrypoint by
+	// default. It is needed when the port seen by the browser is not the one where Pathwae listens.
+	HTTPSPort int `yaml:"https_port,omitempty" json:"https_port,omitempty"`
+
+	// AccessList restricts the clients of all the backends, the backends can have their own restrictions.
+	AccessList `yaml:",inline"`
+
+	// TrustedProxies are the addresses of the proxies in front of Pathwae, the client address is taken from their
+	// X-Forwarded-For header.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty"`
+
+	// ErrorPages are the templates used for the errors of all the backends, see Backend.ErrorPages.
+	ErrorPages ErrorPages `yaml:"error_pages,omitempty" json:"error_pages,omitempty"`
+
+	// parsed TrustedProxies
+	trustedProxies []*net.IPNet
+}
+
+// configFile is the content of the configuration file.
+type configFile struct {
+	Settings `yaml:",inline"`
+	Backends map[string]*Backend `yaml:",inline"`
+}
+
+// settings is the current *Settings, replaced when the configuration is loaded.
+var settings atomic.Value
+
+func init() {
+	settings.Store(&Settings{})
+}
+
+// currentSettings returns the global settings in use.
+func currentSettings() *Settings {
+	return settings.Load().(*Settings)
+}
+
+// startupChanges returns the keys of the settings read only at startup that differ from the running ones.
+func (s *Settings) startupChanges(running *Settings) []string {
+	var keys []string
+	if !reflect.DeepEqual(s.Listeners, running.Listeners) {
+		keys = append(keys, "listeners")
+	}
+	if !reflect.DeepEqual(s.ServerTimeouts, running.ServerTimeouts) {
+		keys = append(keys, "server_timeouts")
+	}
+	return keys
+}
+
+// validate checks the global settings, the returned errors are attached to the setting key but not to a line.
+func (s *Settings) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if s.HTTPSPort < 0 || s.HTTPSPort > 65535 {
+		errs = append(errs, &ConfigError{Host: "https_port", Message: "invalid port " + strconv.Itoa(s.HTTPSPort)})
+	}
+	for _, e := range s.Listeners.validate() {
+		e.Host = "listeners"
+		errs = append(errs, e)
+	}
+	for _, e := range s.AccessList.validate() {
+		e.Host, e.Field = e.Field, ""
+		errs = append(errs, e)
+	}
+	var err error
+	if s.trustedProxies, err = parseNetworks(s.TrustedProxies); err != nil {
+		errs = append(errs, &ConfigError{Host: "trusted_proxies", Message: err.Error()})
+	}
+	for _, e := range s.ErrorPages.validate() {
+		e.Host = "error_pages"
+		e.Field = strings.TrimPrefix(strings.TrimPrefix(e.Field, "error_pages"), ".")
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// isSettingKey returns true if the top level key of the configuration is a global setting, and not a host.
+func isSettingKey(key string) bool {
+	return contains(yamlKeys(reflect.TypeOf(Settings{})), key)
+}
+
+// yamlKeys returns the yaml keys of the struct fields, with the keys of the inlined structs.
+func yamlKeys(t reflect.Type) []string {
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")
+		switch {
+		case len(tag) > 1 && tag[1] == "inline" && t.Field(i).Type.Kind() == reflect.Struct:
+			keys = append(keys, yamlKeys(t.Field(i).Type)...)
+		case tag[0] != "" && tag[0] != "-":
+			keys = append(keys, tag[0])
+		}
+	}
+	return keys
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultIndex is the index file of the directories when not configured.
+const defaultIndex = "index.html"
+
+// precompressed are the encodings of the precompressed files, in the order of preference, with their file extension.
+var precompressed = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Static are the options of the file upstreams (file:///path/to/dir).
+type Static struct {
+	// Index are the files to serve for a directory, "index.html" by default.
+	Index []string `yaml:"index,omitempty" json:"index,omitempty"`
+
+	// Browse lists the content of the directories without index file.
+	Browse bool `yaml:"browse,omitempty" json:"browse,omitempty"`
+
+	// Fallback is the file served when the requested one doesn't exist, e.g. "/index.html" for a single page application.
+	Fallback string `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+}
+
+// browseTemplate lists the content of a directory.
+var browseTemplate = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{ .Path }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 50em; color: #333; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .3em; border-bottom: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>Index of {{ .Path }}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{ if ne .Path "/" }}<tr><td><a href="../">../</a></td><td></td><td></td></tr>{{ end }}
+{{ range .Files }}<tr><td><a href="{{ .URL }}">{{ .Name }}</a></td><td>{{ .Size }}</td><td>{{ .Time }}</td></tr>
+{{ end }}</table>
+</body>
+</html>
+`))
+
+// browseFile is a file of the directory listing.
+type browseFile struct {
+	Name string
+	URL  string
+	Size string
+	Time string
+}
+
+// validate checks the options of the file upstreams.
+func (s *Static) validate() ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	if s == nil {
+		return errs
+	}
+	for _, index := range s.Index {
+		if index == "" || strings.Contains(index, "/") {
+			errs = append(errs, &ConfigError{Field: "static.index", Message: "invalid index file " + index})
+		}
+	}
+	if s.Fallback != "" && !strings.HasPrefix(s.Fallback, "/") {
+		errs = append(errs, &ConfigError{Field: "static.fallback", Message: "fallback must start with /"})
+	}
+	return errs
+}
+
+// isFileURL returns true if the upstream url is a directory to serve.
+func isFileURL(u *url.URL) bool {
+	return u.Scheme == "file"
+}
+
+// serveStatic serves the file of the directory for the request path (rewritten with the backend rules).
+func serveStatic(rw http.ResponseWriter, req *http.Request, backend *Backend, root string, requested *url.URL) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		writeError(rw, req, backend, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	options := backend.Static
+	if options == nil {
+		options = &Static{}
+	}
+
+	// path.Clean removes the "..", so the file is always in the root directory
+	name := path.Clean("/" + requested.Path)
+	file := filepath.Join(root, filepath.FromSlash(name))
+	info, err := os.Stat(file)
+	if err == nil && info.IsDir() {
+		// relative links of the index need the trailing slash
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			target := req.URL.Path + "/"
+			if req.URL.RawQuery != "" {
+				target += "?" + req.URL.RawQuery
+			}
+			http.Redirect(rw, req, target, http.StatusMovedPermanently)
+			return
+		}
+		dir := file
+		file, info = "", nil
+		for _, index := range indexFiles(options) {
+			if i, err := os.Stat(filepath.Join(dir, index)); err == nil && !i.IsDir() {
+				file, info = filepath.Join(dir, index), i
+				break
+			}
+		}
+		if info == nil && options.Browse {
+			serveDirectory(rw, req, backend, dir, req.URL.Path)
+			return
+		}
+	}
+
+	if info == nil || info.IsDir() {
+		if options.Fallback == "" {
+			writeError(rw, req, backend, http.StatusNotFound, "file not found")
+			return
+		}
+		file = filepath.Join(root, filepath.FromSlash(path.Clean(options.Fallback)))
+		if info, err = os.Stat(file); err != nil || info.IsDir() {
+			writeError(rw, req, backend, http.StatusNotFound, "file not found")
+			return
+		}
+	}
+	serveFile(rw, req, backend, file)
+}
+
+// indexFiles returns the index files to look for in the directories.
+func indexFiles(options *Static) []string {
+	if len(options.Index) == 0 {
+		return []string{defaultIndex}
+	}
+	return options.Index
+}
+
+// serveFile sends the file, or its precompressed version if the client accepts it. Ranges and conditional requests are
+// managed by http.ServeContent.
+func serveFile(rw http.ResponseWriter, req *http.Request, backend *Backend, file string) {
+	contentType := mime.TypeByExtension(filepath.Ext(file))
+
+	served := file
+	accepted := req.Header.Get("Accept-Encoding")
+	for _, p := range precompressed {
+		if !acceptsEncoding(accepted, p.encoding) {
+			continue
+		}
+		if info, err := os.Stat(file + p.extension); err == nil && !info.IsDir() {
+			served = file + p.extension
+			rw.Header().Set("Content-Encoding", p.encoding)
+			break
+		}
+	}
+	if served != file || hasPrecompressed(file) {
+		rw.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	f, err := os.Open(served)
+	if err != nil {
+		rw.Header().Del("Content-Encoding")
+		writeError(rw, req, backend, http.StatusNotFound, "file not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		rw.Header().Del("Content-Encoding")
+		writeError(rw, req, backend, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if contentType != "" {
+		rw.Header().Set("Content-Type", contentType)
+	} else if served != file {
+		// the compressed content can't be sniffed by ServeContent
+		rw.Header().Set("Content-Type", "application/octet-stream")
+	}
+	rw.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(rw, req, file, info.ModTime(), f)
+}
+
+// hasPrecompressed returns true if there is a precompressed version of the file.
+func hasPrecompressed(file string) bool {
+	for _, p := range precompressed {
+		if _, err := os.Stat(file + p.extension); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsEncoding returns true if the Accept-Encoding header accepts the encoding.
+func acceptsEncoding(header, encoding string) bool {
+	for _, accepted := range strings.Split(header, ",") {
+		parts := strings.Split(strings.TrimSpace(accepted), ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), encoding) {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serveDirectory lists the files of the directory.
+func serveDirectory(rw http.ResponseWriter, req *http.Request, backend *Backend, dir, public string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		writeError(rw, req, backend, http.StatusNotFound, "file not found")
+		return
+	}
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		writeError(rw, req, backend, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+	files := make([]*browseFile, 0, len(entries))
+	for _, entry := range entries {
+		name, size := entry.Name(), strconv.FormatInt(entry.Size(), 10)
+		if entry.IsDir() {
+			name, size = name+"/", ""
+		}
+		files = append(files, &browseFile{
+			Name: name,
+			URL:  (&url.URL{Path: name}).String(),
+			Size: size,
+			Time: entry.ModTime().Format(time.RFC3339),
+		})
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := browseTemplate.Execute(rw, map[string]interface{}{"Path": public, "Files": files}); err != nil {
+		log.Println("Failed to list", dir, err)
+	}
+}
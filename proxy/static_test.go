@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pathwae")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "site")
+	for file, content := range map[string]string{
+		"secret.txt":           "secret",
+		"site/index.html":      "home",
+		"site/docs/index.html": "docs",
+		"site/app.js":          "app",
+	} {
+		file = filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		url      string
+		fallback string
+		status   int
+		body     string
+	}{
+		{url: "/", status: http.StatusOK, body: "home"},
+		{url: "/app.js", status: http.StatusOK, body: "app"},
+		{url: "/docs/", status: http.StatusOK, body: "docs"},
+		{url: "/docs", status: http.StatusMovedPermanently},
+		{url: "/missing.js", status: http.StatusNotFound},
+		{url: "/missing.js", fallback: "/index.html", status: http.StatusOK, body: "home"},
+		// the files out of the root directory are never served
+		{url: "/../secret.txt", status: http.StatusNotFound},
+		{url: "/%2e%2e/secret.txt", status: http.StatusNotFound},
+		{url: "/docs/../../secret.txt", status: http.StatusNotFound},
+		{url: "/..%2fsecret.txt", status: http.StatusNotFound},
+		{url: "/missing.js", fallback: "/../secret.txt", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		backend := &Backend{Static: &Static{Fallback: test.fallback}}
+		// the path is not cleaned, like the ones sent by the clients
+		requested, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "http://static.localhost/", nil)
+		req.URL = requested
+		rec := httptest.NewRecorder()
+		serveStatic(rec, req, backend, root, requested)
+		if rec.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.url, rec.Code, test.status)
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("%s: got %q, want %q", test.url, rec.Body.String(), test.body)
+		}
+		if strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("%s: the file out of the root is served", test.url)
+		}
+	}
+}
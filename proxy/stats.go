@@ -6,20 +6,61 @@ import (
 	"sync"
 )
 
+// UnknownHostsStats is the name of the stats of the requests refused for the hosts that are not configured.
+const UnknownHostsStats = "_unknown"
+
 var (
 	Stats    map[string][]*Stat
-	StatChan chan http.Request
+	StatChan chan *Stat
 	lock     sync.Mutex
 )
 
+// Stat is a request received for a backend.
 type Stat struct {
 	Path   string `json:"path"`
 	Method string `json:"method"`
+	Route  string `json:"route,omitempty"`
+
+	// Status is set for the requests refused by the proxy.
+	Status int `json:"status,omitempty"`
+
+	// Event tells why the request was refused, e.g. "blocked", or what happened to it, e.g. "timeout".
+	Event string `json:"event,omitempty"`
+
+	// Client is the address of the client, set for the refused requests.
+	Client string `json:"client,omitempty"`
+
+	// Retries is the number of retries made for the request, set with the "retried" event.
+	Retries int `json:"retries,omitempty"`
+
+	// Upstream is the url of the upstream that failed, the one of the last attempt, set with the "timeout" event.
+	Upstream string `json:"upstream,omitempty"`
+
+	// name of the backend
+	host string
+}
+
+// newStat creates a stat for the given request sent to the named backend, route is the path of the matched route (can be
+// empty).
+func newStat(req *http.Request, backend, route string) *Stat {
+	return &Stat{
+		Path:   req.URL.Path,
+		Method: req.Method,
+		Route:  route,
+		host:   backend,
+	}
+}
+
+// sendStat sends the stat to the stats handler without blocking the request.
+func sendStat(stat *Stat) {
+	go func() {
+		StatChan <- stat
+	}()
 }
 
 func init() {
 	lock = sync.Mutex{}
-	StatChan = make(chan http.Request, 100)
+	StatChan = make(chan *Stat, 100)
 	Stats = make(map[string][]*Stat)
 	go func() {
 		for {
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Origins of the routing table changes.
+const (
+	OriginFile     = "file"     // configuration loaded at startup
+	OriginReload   = "reload"   // configuration file reloaded
+	OriginAPI      = "api"      // change made through the API
+	OriginRollback = "rollback" // rollback to a previous revision
+)
+
+var (
+	// table is the current *routingTable, shared by all the servers. It is never modified, each change stores a new one.
+	table atomic.Value
+
+	// tableLock serializes the changes of the table, reads don't need it.
+	tableLock sync.Mutex
+)
+
+func init() {
+	table.Store(newRoutingTable(nil))
+}
+
+// routingTable is a snapshot of the configured backends with the router to find them. It must not be modified once
+// stored, so reads are lock-free.
+type routingTable struct {
+	backends map[string]*Backend
+	router   *router
+}
+
+// newRoutingTable creates a table for the given backends.
+func newRoutingTable(backends map[string]*Backend) *routingTable {
+	if backends == nil {
+		backends = make(map[string]*Backend)
+	}
+	return &routingTable{
+		backends: backends,
+		router:   newRouter(backends),
+	}
+}
+
+// currentTable returns the routing table in use.
+func currentTable() *routingTable {
+	return table.Load().(*routingTable)
+}
+
+// updateTable applies a change to the routing table. The update function receives a copy of the current backends that it
+// can modify, and returns the changes to notify. Nothing is stored if it returns an error or no change. The origin tells
+// where the change comes from, it is recorded in the history. API changes and rollbacks are written in the configuration
+// file if the persistence is enabled.
+func updateTable(origin string, update func(backends map[string]*Backend) ([]*Change, error)) error {
+	tableLock.Lock()
+	backends := copyBackends(currentTable().backends)
+	changes, err := update(backends)
+	if err == nil && len(changes) > 0 {
+		table.Store(newRoutingTable(backends))
+		syncHealthChecks(backends)
+		pruneRateLimiters(backends)
+		pruneTransports(backends)
+		recordRevision(origin, changes, backends)
+		pruneUpstreamStates(backends)
+		if origin == OriginAPI || origin == OriginRollback {
+			persist(backends)
+		}
+		notifyChanges(changes...)
+	}
+	tableLock.Unlock()
+	return err
+}
+
+// copyBackends returns a copy of the backends map, the backends are not copied.
+func copyBackends(servers map[string]*Backend) map[string]*Backend {
+	backends := make(map[string]*Backend, len(servers))
+	for name, b := range servers {
+		backends[name] = b
+	}
+	return backends
+}
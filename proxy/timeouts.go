@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default timeouts, a zero timeout means no limit.
+const (
+	defaultReadHeaderTimeout     = 10 * time.Second
+	defaultServerIdleTimeout     = 2 * time.Minute
+	defaultDialTimeout           = 10 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 2 * time.Minute
+	defaultUpstreamIdleTimeout   = 90 * time.Second
+)
+
+// Duration is a time.Duration written as "10s" or "1m30s" in the configuration and in the API.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return d.parse(value)
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.parse(value)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// parse sets the duration from a string like "10s", a negative duration is refused.
+func (d *Duration) parse(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if parsed < 0 {
+		return errors.New("negative duration " + value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// or returns the duration, or the default one if it is not set.
+func (d Duration) or(defaultDuration time.Duration) time.Duration {
+	if d == 0 {
+		return defaultDuration
+	}
+	return time.Duration(d)
+}
+
+// ServerTimeouts are the timeouts of the connections of the clients, read only at startup. They protect Pathwae from the
+// clients that never finish to send their requests.
+type ServerTimeouts struct {
+	// ReadHeader is the time to read the request headers, 10s by default.
+	ReadHeader Duration `yaml:"read_header,omitempty" json:"read_header,omitempty"`
+
+	// Read is the time to read the whole request, with the body. No limit by default, for the slow uploads.
+	Read Duration `yaml:"read,omitempty" json:"read,omitempty"`
+
+	// Write is the time to send the response. No limit by default, for the downloads and the event streams.
+	Write Duration `yaml:"write,omitempty" json:"write,omitempty"`
+
+	// Idle is the time to keep an idle connection open, waiting for the next request, 2m by default.
+	Idle Duration `yaml:"idle,omitempty" json:"idle,omitempty"`
+}
+
+// Timeouts are the timeouts of the requests sent to the upstreams of a backend. A request that takes too long gets a
+// "504 Gateway Timeout".
+type Timeouts struct {
+	// Dial is the time to connect to the upstream, 10s by default.
+	Dial Duration `yaml:"dial,omitempty" json:"dial,omitempty"`
+
+	// TLSHandshake is the time to establish the TLS session with an https upstream, 10s by default.
+	TLSHandshake Duration `yaml:"tls_handshake,omitempty" json:"tls_handshake,omitempty"`
+
+	// ResponseHeader is the time to wait for the response headers once the request is sent, 2m by default.
+	ResponseHeader Duration `yaml:"response_header,omitempty" json:"response_header,omitempty"`
+
+	// Total is the time for the whole exchange, including the response body. No limit by default, it would cut the
+	// websockets and the event streams.
+	Total Duration `yaml:"total,omitempty" json:"total,omitempty"`
+
+	// Idle is the time to keep an unused connection to the upstream, 90s by default.
+	Idle Duration `yaml:"idle,omitempty" json:"idle,omitempty"`
+}
+
+// backendTransport is the transport of a backend, with the timeouts used to create it.
+type backendTransport struct {
+	config    Timeouts
+	transport *http.Transport
+}
+
+var (
+	// transports are the transports by backend name, so the connections to the upstreams are reused.
+	transports     = make(map[string]*backendTransport)
+	transportsLock sync.Mutex
+)
+
+// apply sets the timeouts of the server, with the defaults.
+func (t ServerTimeouts) apply(server *http.Server) {
+	server.ReadHeaderTimeout = t.ReadHeader.or(defaultReadHeaderTimeout)
+	server.ReadTimeout = time.Duration(t.Read)
+	server.WriteTimeout = time.Duration(t.Write)
+	server.IdleTimeout = t.Idle.or(defaultServerIdleTimeout)
+}
+
+// getTransport returns the transport of the backend, a new one is created if the timeouts changed.
+func getTransport(name string, timeouts *Timeouts) *http.Transport {
+	config := Timeouts{}
+	if timeouts != nil {
+		config = *timeouts
+	}
+
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+	if t, ok := transports[name]; ok {
+		if t.config == config {
+			return t.transport
+		}
+		t.transport.CloseIdleConnections()
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   config.Dial.or(defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = config.TLSHandshake.or(defaultTLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = config.ResponseHeader.or(defaultResponseHeaderTimeout)
+	transport.IdleConnTimeout = config.Idle.or(defaultUpstreamIdleTimeout)
+	transports[name] = &backendTransport{config: config, transport: transport}
+	return transport
+}
+
+// pruneTransports closes the transports of the backends that are removed.
+func pruneTransports(backends map[string]*Backend) {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+	for name, t := range transports {
+		if _, ok := backends[name]; !ok {
+			t.transport.CloseIdleConnections()
+			delete(transports, name)
+		}
+	}
+}
+
+// withTimeout returns the request with the total timeout of the backend, and the function to release it.
+func (b *Backend) withTimeout(req *http.Request) (*http.Request, context.CancelFunc) {
+	if b.Timeouts == nil || b.Timeouts.Total == 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), time.Duration(b.Timeouts.Total))
+	return req.WithContext(ctx), cancel
+}
+
+// isTimeout returns true if the error is a timeout of the upstream.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
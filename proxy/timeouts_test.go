@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransportsPruned(t *testing.T) {
+	defer resetHistory()()
+	defer replaceBackends(OriginReload, currentTable().backends)
+
+	closed := make(chan struct{}, 1)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	// the disabled backends are not checked, the only connection is the one of the request
+	loadTestConfig(t, `a.localhost:
+  to: `+upstream.URL+`
+  enabled: false
+b.localhost:
+  to: `+upstream.URL+`
+  enabled: false
+`)
+	// the connection stays open for the next requests
+	resp, err := (&http.Client{Transport: getTransport("a.localhost", nil)}).Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	getTransport("b.localhost", nil)
+
+	loadTestConfig(t, `b.localhost:
+  to: `+upstream.URL+`
+  enabled: false
+`)
+	transportsLock.Lock()
+	_, a := transports["a.localhost"]
+	_, b := transports["b.localhost"]
+	transportsLock.Unlock()
+	if a || !b {
+		t.Errorf("got the transport of a.localhost %v and b.localhost %v, want only b.localhost", a, b)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("the idle connection of the removed backend is not closed")
+	}
+}
@@ -2,20 +2,18 @@ package proxy
 
 import (
 	"crypto/tls"
-	"net"
 	"net/url"
 	"strings"
-	"time"
 )
 
 // GetBackends returns a list of all servers.
 func GetBackends() map[string]*Backend {
-	return backendList
+	return copyBackends(currentTable().backends)
 }
 
 // GetBackend returns the server with the given name.
 func GetBackend(backendName string) *Backend {
-	if s, ok := backendList[backendName]; ok {
+	if s, ok := currentTable().backends[backendName]; ok {
 		return s
 	}
 	return nil
@@ -26,40 +24,12 @@ func GetCertForBackend(backendName string) *tls.Certificate {
 	return certCache[backendName]
 }
 
-// IsBackendUp returns true if the server is up and running.
-func IsBackendUp(backend Backend) bool {
-
-	if backend.Enabled == nil || !*backend.Enabled {
-		return false
-	}
-
-	if !strings.HasPrefix(backend.To, "http") {
-		backend.To = "http://" + backend.To
-	}
-	//parse the url
-	parsed, err := url.Parse(backend.To)
-	if err != nil {
-		return false
-	}
-	//get the hostname
-	hostname := parsed.Hostname()
-	//get the port
-	port := parsed.Port()
-	if port == "" {
-		if parsed.Scheme == "https" {
-			port = "443"
-		} else {
-			port = "80"
-		}
-	}
-
-	// check connection
-	conn, err := net.DialTimeout("tcp", hostname+":"+port, time.Second*5)
-	if err != nil {
-		return false
+// parseURL parses the backend url, "http" scheme is used if no scheme is provided.
+func parseURL(to string) (*url.URL, error) {
+	if !strings.Contains(to, "://") {
+		to = "http://" + to
 	}
-	conn.Close()
-	return true
+	return url.Parse(to)
 }
 
 // GetErrors returns the errors for the given server.
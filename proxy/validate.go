@@ -0,0 +1,455 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlLineError matches the "line N: message" errors returned by the yaml decoder.
+var yamlLineError = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlUnknownField matches the message of the strict decoder when a key is unknown.
+var yamlUnknownField = regexp.MustCompile(`^field (\S+) not found in type`)
+
+// yamlDuplicateKey matches the message of the strict decoder when a key is declared twice.
+var yamlDuplicateKey = regexp.MustCompile(`^key "(.*)" already set in map`)
+
+// ConfigError is a problem found in the configuration.
+type ConfigError struct {
+	// Host is the backend name, empty if the error is not related to a backend.
+	Host string `json:"host,omitempty"`
+
+	// Field is the configuration key in error.
+	Field string `json:"field,omitempty"`
+
+	// Line is the line number in the configuration file, 0 if unknown.
+	Line int `json:"line,omitempty"`
+
+	// Message explains the problem.
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *ConfigError) Error() string {
+	parts := make([]string, 0, 4)
+	if e.Line > 0 {
+		parts = append(parts, "line "+strconv.Itoa(e.Line))
+	}
+	if e.Host != "" {
+		parts = append(parts, e.Host)
+	}
+	if e.Field != "" {
+		parts = append(parts, e.Field)
+	}
+	return strings.Join(append(parts, e.Message), ": ")
+}
+
+// ConfigErrors is a list of configuration problems, it is returned as error by ParseYAMLConfig.
+type ConfigErrors []*ConfigError
+
+// Error implements the error interface.
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// ValidateConfig checks the configuration content and returns all the problems found, sorted by line. It returns nil if the
+// configuration is valid.
+func ValidateConfig(content string) ConfigErrors {
+	_, errs := parseConfig(content)
+	return errs
+}
+
+// parseConfig decodes and checks the configuration content. The configuration is returned only if there is no error.
+func parseConfig(content string) (*configFile, ConfigErrors) {
+	conf := &configFile{}
+	lines := newConfigLines(content)
+	errs := make(ConfigErrors, 0)
+
+	err := yaml.UnmarshalStrict([]byte(content), conf)
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		// the decoder continues on unknown fields and type problems, so we can check the rest
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, lines.yamlError(msg))
+		}
+	} else if err != nil {
+		return nil, ConfigErrors{lines.yamlError(err.Error())}
+	}
+
+	for _, e := range conf.Settings.validate() {
+		e.Line = lines.field(e.Host, e.Field)
+		errs = append(errs, e)
+	}
+
+	servers := conf.Backends
+	seen := make(map[string]string, len(servers))
+	for name, b := range servers {
+		if other, ok := seen[strings.ToLower(name)]; ok {
+			errs = append(errs, &ConfigError{
+				Host:    name,
+				Line:    lines.host(name),
+				Message: "duplicate host, already declared as " + other,
+			})
+		}
+		seen[strings.ToLower(name)] = name
+
+		if b == nil {
+			errs = append(errs, &ConfigError{Host: name, Line: lines.host(name), Message: "configuration is empty"})
+			continue
+		}
+		b.order = lines.host(name)
+		for _, e := range b.validateWith(name, &conf.Settings) {
+			e.Host = name
+			e.Line = lines.field(name, e.Field)
+			errs = append(errs, e)
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Line < errs[j].Line
+		})
+		return nil, errs
+	}
+	return conf, nil
+}
+
+// validateWith checks the backend configuration for the given host name, and its entrypoints against the listeners of
+// the settings. The returned errors are not attached to a host or a line.
+func (b *Backend) validateWith(name string, s *Settings) ConfigErrors {
+	return append(b.validate(name), s.Listeners.checkEntrypoints(b.Entrypoints)...)
+}
+
+// validate checks the backend configuration for the given host name, the returned errors are not attached to a host or a
+// line.
+func (b *Backend) validate(name string) ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	captures, err := validateHost(name)
+	if err != nil {
+		errs = append(errs, &ConfigError{Message: err.Error()})
+	}
+	if len(b.To) == 0 && len(b.Routes) == 0 && len(b.Redirects) == 0 {
+		errs = append(errs, &ConfigError{Field: "to", Message: "backend url is empty"})
+	}
+	errs = append(errs, validateUpstreams("to", b.To, captures)...)
+	errs = append(errs, validateUpstreams("backup", b.Backup, captures)...)
+	if err := validateBalance(b.Balance); err != nil {
+		errs = append(errs, &ConfigError{Field: "balance", Message: err.Error()})
+	}
+	for i, route := range b.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		if err := route.compile(); err != nil {
+			errs = append(errs, &ConfigError{Field: field, Message: err.Error()})
+		}
+		if len(route.To) == 0 {
+			errs = append(errs, &ConfigError{Field: field + ".to", Message: "url is empty"})
+		}
+		errs = append(errs, validateUpstreams(field+".to", route.To, captures)...)
+		errs = append(errs, route.PathRewrite.validate(field+".")...)
+	}
+	errs = append(errs, b.PathRewrite.validate("")...)
+	errs = append(errs, b.Static.validate()...)
+	for i, r := range b.Redirects {
+		field := fmt.Sprintf("redirects[%d]", i)
+		if r == nil {
+			errs = append(errs, &ConfigError{Field: field, Message: "redirect target is empty"})
+			continue
+		}
+		errs = append(errs, r.validate(field, captures)...)
+	}
+	errs = append(errs, b.AccessList.validate()...)
+	errs = append(errs, b.RateLimit.validate()...)
+	errs = append(errs, b.Retry.validate()...)
+	errs = append(errs, b.HealthCheck.validate()...)
+	errs = append(errs, b.CircuitBreaker.validate()...)
+	errs = append(errs, b.Maintenance.validate()...)
+	errs = append(errs, b.SecurityHeaders.validate()...)
+	errs = append(errs, b.BasicAuth.validate()...)
+	errs = append(errs, b.RequestHeaders.validate("request_headers")...)
+	errs = append(errs, b.ResponseHeaders.validate("response_headers")...)
+	errs = append(errs, b.ErrorPages.validate()...)
+	return errs
+}
+
+// validateUpstreams checks the urls of the upstreams, captures are the placeholders that can be used in the urls.
+func validateUpstreams(field string, upstreams Upstreams, captures []string) ConfigErrors {
+	errs := make(ConfigErrors, 0)
+	for _, u := range upstreams {
+		if u == nil {
+			errs = append(errs, &ConfigError{Field: field, Message: "url is empty"})
+			continue
+		}
+		// placeholders are checked here, and replaced by a valid value to check the url
+		values := make(map[string]string)
+		for _, name := range placeholders(u.URL) {
+			if !contains(captures, name) {
+				errs = append(errs, &ConfigError{Field: field, Message: "unknown placeholder {" + name + "} in " + u.URL})
+			}
+			values[name] = "0"
+		}
+		if err := validateURL(substitute(u.URL, values)); err != nil {
+			errs = append(errs, &ConfigError{Field: field, Message: err.Error()})
+		}
+		if u.Weight < 0 {
+			errs = append(errs, &ConfigError{Field: field, Message: "negative weight for " + u.URL})
+		}
+	}
+	return errs
+}
+
+// validateURL checks that the backend url can be used to proxy requests.
+func validateURL(to string) error {
+	if to == "" {
+		return fmt.Errorf("url is empty")
+	}
+	parsed, err := parseURL(to)
+	if err != nil {
+		return err
+	}
+	if isFileURL(parsed) {
+		if parsed.Host != "" || !strings.HasPrefix(parsed.Path, "/") {
+			return fmt.Errorf("invalid file url %s, use an absolute path like file:///srv/site", to)
+		}
+		return nil
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("invalid scheme %q in %s, only http, https and file are supported", parsed.Scheme, to)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("no host in url %s", to)
+	}
+	return nil
+}
+
+// contains returns true if the value is in the list.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// configLines finds the line numbers of the hosts and their fields in the configuration content. It only understands the
+// block style used in the configuration, errors in flow style are reported on the host line.
+type configLines struct {
+	lines []string
+	hosts []configHost
+}
+
+// configHost is a host declaration, the block ends at the next host.
+type configHost struct {
+	name  string
+	start int // 0 based line index of the host key
+	end   int // 0 based line index of the next host key
+}
+
+// newConfigLines indexes the top level keys of the content.
+func newConfigLines(content string) *configLines {
+	cl := &configLines{lines: strings.Split(content, "\n")}
+	for i, line := range cl.lines {
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' || line[0] == '-' {
+			continue
+		}
+		key := configKey(strings.TrimSpace(line))
+		if n := len(cl.hosts); n > 0 {
+			cl.hosts[n-1].end = i
+		}
+		cl.hosts = append(cl.hosts, configHost{name: key, start: i, end: len(cl.lines)})
+	}
+	return cl
+}
+
+// configKey extracts the key of a "key: value" line, the key can be quoted.
+func configKey(line string) string {
+	if line != "" && (line[0] == '"' || line[0] == '\'') {
+		// the quoted key is decoded, it can have escaped characters
+		for end := 1; end < len(line); end++ {
+			switch {
+			case line[0] == '"' && line[end] == '\\':
+				end++
+			case line[0] == '\'' && strings.HasPrefix(line[end:], "''"):
+				end++
+			case line[end] == line[0]:
+				var key string
+				if yaml.Unmarshal([]byte(line[:end+1]), &key) != nil {
+					return line[1:end]
+				}
+				return key
+			}
+		}
+	}
+	if idx := strings.Index(line, ": "); idx >= 0 {
+		return line[:idx]
+	}
+	return strings.TrimSuffix(line, ":")
+}
+
+// host returns the line number of the last declaration of the host, 0 if not found.
+func (cl *configLines) host(name string) int {
+	for i := len(cl.hosts) - 1; i >= 0; i-- {
+		if cl.hosts[i].name == name {
+			return cl.hosts[i].start + 1
+		}
+	}
+	return 0
+}
+
+// hostAt returns the host declared at the given line number.
+func (cl *configLines) hostAt(line int) string {
+	for _, h := range cl.hosts {
+		if line-1 >= h.start && line-1 < h.end {
+			return h.name
+		}
+	}
+	return ""
+}
+
+// field returns the line number of a field of the host. Field can be a path like "routes[1].to". The host line is returned
+// if the field is not found.
+func (cl *configLines) field(name, field string) int {
+	line := cl.host(name)
+	if line == 0 || field == "" {
+		return line
+	}
+	var block configHost
+	for _, h := range cl.hosts {
+		if h.start == line-1 {
+			block = h
+		}
+	}
+
+	start, end := block.start+1, block.end
+	for _, part := range strings.Split(field, ".") {
+		key, index := part, -1
+		if idx := strings.Index(part, "["); idx > 0 {
+			key = part[:idx]
+			index, _ = strconv.Atoi(strings.TrimSuffix(part[idx+1:], "]"))
+		}
+		found := cl.key(start, end, key)
+		if found < 0 {
+			return line
+		}
+		line = found + 1
+		start, end = found+1, cl.blockEnd(found, end, keyIndent)
+		if index < 0 {
+			continue
+		}
+		item := cl.item(start, end, index)
+		if item < 0 {
+			return line
+		}
+		// the first key of the item is on its line
+		line = item + 1
+		start, end = item, cl.blockEnd(item, end, spaceIndent)
+	}
+	return line
+}
+
+// key returns the line index of the key in the block, only the keys at the indentation of the first one are searched.
+// It returns -1 if the key is not found.
+func (cl *configLines) key(start, end int, key string) int {
+	indent := -1
+	for i := start; i < end; i++ {
+		if isBlankLine(cl.lines[i]) {
+			continue
+		}
+		if indent < 0 {
+			indent = keyIndent(cl.lines[i])
+		}
+		if keyIndent(cl.lines[i]) == indent && configKey(strings.TrimLeft(strings.TrimSpace(cl.lines[i]), "- ")) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// item returns the line index of the list item in the block, only the items at the indentation of the first one are
+// counted. It returns -1 if the item is not found.
+func (cl *configLines) item(start, end, index int) int {
+	indent, count := -1, -1
+	for i := start; i < end; i++ {
+		trimmed := strings.TrimSpace(cl.lines[i])
+		if isBlankLine(cl.lines[i]) || !strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		if indent < 0 {
+			indent = spaceIndent(cl.lines[i])
+		}
+		if spaceIndent(cl.lines[i]) != indent {
+			continue
+		}
+		if count++; count == index {
+			return i
+		}
+	}
+	return -1
+}
+
+// blockEnd returns the line index after the block of the line at the given index, the lines of the block are more
+// indented.
+func (cl *configLines) blockEnd(index, end int, indent func(string) int) int {
+	limit := indent(cl.lines[index])
+	for i := index + 1; i < end; i++ {
+		if !isBlankLine(cl.lines[i]) && indent(cl.lines[i]) <= limit {
+			return i
+		}
+	}
+	return end
+}
+
+// isBlankLine returns true for the empty lines and the comments.
+func isBlankLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || trimmed[0] == '#'
+}
+
+// spaceIndent returns the number of spaces before the content of the line.
+func spaceIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// keyIndent returns the column of the key of the line, after the list markers.
+func keyIndent(line string) int {
+	rest := strings.TrimLeft(line, " ")
+	for strings.HasPrefix(rest, "- ") {
+		rest = strings.TrimLeft(rest[1:], " ")
+	}
+	return len(line) - len(rest)
+}
+
+// yamlError converts an error message of the yaml decoder to a ConfigError.
+func (cl *configLines) yamlError(msg string) *ConfigError {
+	matches := yamlLineError.FindStringSubmatch(msg)
+	if matches == nil {
+		return &ConfigError{Message: strings.TrimPrefix(msg, "yaml: ")}
+	}
+	line, _ := strconv.Atoi(matches[1])
+	e := &ConfigError{
+		Host:    cl.hostAt(line),
+		Line:    line,
+		Message: matches[2],
+	}
+	if field := yamlUnknownField.FindStringSubmatch(matches[2]); field != nil {
+		e.Field = field[1]
+		e.Message = "unknown key"
+	}
+	if key := yamlDuplicateKey.FindStringSubmatch(matches[2]); key != nil {
+		if key[1] == e.Host {
+			e.Message = "duplicate host"
+		} else {
+			e.Field = key[1]
+			e.Message = "duplicate key"
+		}
+	}
+	return e
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"testing"
+)
+
+const linesConfig = `listeners:
+  http: ":8001"
+
+app.localhost:
+  routes:
+    - path: /api
+      to:
+        - http://a:8080
+        - http://b:8080
+    # the web route
+    - path: /web
+      to: http://c:8080
+  to: http://app:8080
+  health_check:
+    path: /healthz
+"quoted.localhost":
+  to: http://q
+  redirects:
+  - pattern: ^/old
+    to: /new
+  - pattern: ^/older
+    to: /new
+`
+
+func TestConfigLinesField(t *testing.T) {
+	lines := newConfigLines(linesConfig)
+	tests := []struct {
+		host  string
+		field string
+		line  int
+	}{
+		{host: "listeners", field: "http", line: 2},
+		{host: "app.localhost", line: 4},
+		{host: "app.localhost", field: "routes", line: 5},
+		{host: "app.localhost", field: "routes[0]", line: 6},
+		{host: "app.localhost", field: "routes[0].to", line: 7},
+		{host: "app.localhost", field: "routes[1]", line: 11},
+		{host: "app.localhost", field: "routes[1].to", line: 12},
+		{host: "app.localhost", field: "routes[1].path", line: 11},
+		{host: "app.localhost", field: "to", line: 13},
+		{host: "app.localhost", field: "health_check.path", line: 15},
+		{host: "quoted.localhost", field: "to", line: 17},
+		{host: "quoted.localhost", field: "redirects[1]", line: 21},
+		{host: "quoted.localhost", field: "redirects[1].to", line: 22},
+		// the closest line found is returned for the missing fields
+		{host: "app.localhost", field: "balance", line: 4},
+		{host: "app.localhost", field: "routes[3]", line: 5},
+		{host: "app.localhost", field: "health_check.status", line: 14},
+		{host: "missing.localhost", field: "to", line: 0},
+	}
+	for _, test := range tests {
+		if line := lines.field(test.host, test.field); line != test.line {
+			t.Errorf("%s %s: line %d, want %d", test.host, test.field, line, test.line)
+		}
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		errors  []ConfigError
+	}{
+		{
+			name:    "valid",
+			content: linesConfig,
+		},
+		{
+			name: "backend fields",
+			content: `app.localhost:
+  routes:
+    - path: /api
+      to:
+        - http://a:8080
+    - path: /web
+      to: ftp://c
+  balance: unknown
+other.localhost:
+  to: http://o
+  health_check:
+    path: healthz
+`,
+			errors: []ConfigError{
+				{Host: "app.localhost", Field: "routes[1].to", Line: 7},
+				{Host: "app.localhost", Field: "balance", Line: 8},
+				{Host: "other.localhost", Field: "health_check.path", Line: 12},
+			},
+		},
+		{
+			name: "unknown key",
+			content: `app.localhost:
+  to: http://app
+
+other.localhost:
+  to: http://o
+  unknown_key: 1
+`,
+			errors: []ConfigError{{Host: "other.localhost", Field: "unknown_key", Line: 6}},
+		},
+		{
+			name: "placeholders",
+			content: `"*.app.localhost":
+  to: http://{1}:8080
+"~^(?P<svc>[a-z]+)\\.dev\\.localhost$":
+  to: http://{name}:8080
+`,
+			errors: []ConfigError{{Host: `~^(?P<svc>[a-z]+)\.dev\.localhost$`, Field: "to", Line: 4}},
+		},
+		{
+			name: "empty backend",
+			content: `app.localhost:
+  to: http://app
+empty.localhost:
+`,
+			errors: []ConfigError{{Host: "empty.localhost", Line: 3}},
+		},
+	}
+	for _, test := range tests {
+		conf, errs := parseConfig(test.content)
+		if len(errs) != len(test.errors) {
+			t.Errorf("%s: %d errors, want %d: %v", test.name, len(errs), len(test.errors), errs)
+			continue
+		}
+		if len(errs) == 0 && conf == nil {
+			t.Errorf("%s: no configuration without error", test.name)
+		}
+		for i, e := range errs {
+			want := test.errors[i]
+			if e.Host != want.Host || e.Field != want.Field || e.Line != want.Line {
+				t.Errorf("%s: error %q at %s %s line %d, want %s %s line %d",
+					test.name, e.Message, e.Host, e.Field, e.Line, want.Host, want.Field, want.Line)
+			}
+		}
+		if len(errs) > 0 && len(ValidateConfig(test.content)) != len(errs) {
+			t.Errorf("%s: ValidateConfig doesn't return the errors of parseConfig", test.name)
+		}
+	}
+}
+
+func TestConfigKey(t *testing.T) {
+	tests := map[string]string{
+		`app.localhost:`:                     "app.localhost",
+		`to: http://app`:                     "to",
+		`"*.app.localhost":`:                 "*.app.localhost",
+		`"~^(?P<svc>[a-z]+)\\.localhost$":`:  `~^(?P<svc>[a-z]+)\.localhost$`,
+		`'~^([a-z]+)\.localhost$': # regex`:  `~^([a-z]+)\.localhost$`,
+		`'it''s.localhost':`:                 "it's.localhost",
+		`"say \"hi\".localhost": {to: http}`: `say "hi".localhost`,
+	}
+	for line, key := range tests {
+		if k := configKey(line); k != key {
+			t.Errorf("%s: key %q, want %q", line, k, key)
+		}
+	}
+}
@@ -2,27 +2,113 @@ package service
 
 import (
 	"crypto/tls"
+	"errors"
+	"net"
+	"os"
 	"pathwae/api"
 	"pathwae/proxy"
+	"strconv"
+	"syscall"
 )
 
-func Start(conf string) {
+// unprivilegedPortOffset is added to the ports below 1024 when Pathwae is not allowed to use them, 80 becomes 10080.
+const unprivilegedPortOffset = 10000
+
+// Load loads the configuration content, Pathwae stops if it is not valid.
+func Load(conf string) {
+	if _, err := proxy.LoadYAMLConfig(conf); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+}
+
+// Start opens the listeners of the loaded configuration and serves the API, it doesn't return.
+func Start() {
 	log.Println("Starting services...")
+	listeners := proxy.GetListeners()
+
 	// create tls config for the tlsServer
-	tlsServer := proxy.NewReversProxy(conf, ":https") // :443
-	config := &tls.Config{
-		GetCertificate: tlsServer.GetCerts,
+	if addr := listenAddress(listeners.HTTPS, "LISTEN_HTTPS", ":https"); addr != "off" {
+		log.Println("Starting https reverse proxy...")
+		startProxy(proxy.EntrypointHTTPS, addr, true)
 	}
-	tlsServer.TLSConfig = config
-	log.Println("Starting https reverse proxy...")
-	go tlsServer.ListenAndServeTLS("", "")
 
 	// create a http server
-	httpServer := proxy.NewReversProxy(conf, ":http") // :80
-	log.Println("Starting http reverse proxy...")
-	go httpServer.ListenAndServe()
+	if addr := listenAddress(listeners.HTTP, "LISTEN_HTTP", ":http"); addr != "off" {
+		log.Println("Starting http reverse proxy...")
+		startProxy(proxy.EntrypointHTTP, addr, false)
+	}
+
+	for _, entrypoint := range listeners.Entrypoints {
+		log.Printf("Starting %s reverse proxy...", entrypoint.Name)
+		startProxy(entrypoint.Name, entrypoint.Address, entrypoint.TLS)
+	}
 
 	// start the API
-	log.Println("Starting API on port :8080...")
-	log.Fatal(api.Start(":8080"))
+	addr := listenAddress(listeners.API, "LISTEN_API", ":8080")
+	if addr == "off" {
+		log.Println("API is disabled")
+		select {}
+	}
+	ln, err := listen("API", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Starting API on", ln.Addr())
+	log.Fatal(api.Start(ln))
+}
+
+// listenAddress returns the address to listen: the environment variable wins over the configuration.
+func listenAddress(configured, env, defaultAddress string) string {
+	if address := os.Getenv(env); address != "" {
+		return address
+	}
+	if configured != "" {
+		return configured
+	}
+	return defaultAddress
+}
+
+// startProxy serves the backends on the address, in background.
+func startProxy(name, address string, useTLS bool) {
+	ln, err := listen(name, address)
+	if err != nil {
+		log.Printf("Failed to start the %s reverse proxy: %v", name, err)
+		return
+	}
+
+	server := proxy.NewReversProxy(address)
+	server.Entrypoint = name
+	log.Printf("Reverse proxy %s listening on %s", name, ln.Addr())
+	proxy.SetEntrypointAddress(name, ln.Addr().String(), useTLS)
+	if useTLS {
+		server.TLSConfig = &tls.Config{
+			GetCertificate: server.GetCerts,
+		}
+		go server.ServeTLS(ln, "", "")
+	} else {
+		go server.Serve(ln)
+	}
+}
+
+// listen opens the address. If the port is below 1024 and the user is not allowed to use it (rootless containers), the
+// port + 10000 is used instead.
+func listen(name, address string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err == nil || !errors.Is(err, syscall.EACCES) {
+		return ln, err
+	}
+	host, port, splitErr := net.SplitHostPort(address)
+	if splitErr != nil {
+		return nil, err
+	}
+	p, portErr := net.LookupPort("tcp", port)
+	if portErr != nil || p >= 1024 {
+		return nil, err
+	}
+
+	fallback := net.JoinHostPort(host, strconv.Itoa(p+unprivilegedPortOffset))
+	log.Printf("Not allowed to listen on %s for %s, using %s instead. "+
+		"Allow the low ports with \"sysctl -w net.ipv4.ip_unprivileged_port_start=0\", or map the ports of the container.",
+		address, name, fallback)
+	return net.Listen("tcp", fallback)
 }
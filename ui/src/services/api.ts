@@ -51,6 +51,17 @@ export default class Api {
 
   // Change state of the backend
   async setBackend(name: string, _new: Backend) {
+    return fetch(`${this.BASE_URL}/backend/${name}`, {
+      method: "PUT",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify(_new),
+    });
+  }
+
+  // Create a new backend
+  async createBackend(name: string, _new: Backend) {
     return fetch(`${this.BASE_URL}/backend/${name}`, {
       method: "POST",
       headers: {
@@ -59,4 +70,11 @@ export default class Api {
       body: JSON.stringify(_new),
     });
   }
+
+  // Remove a backend
+  async deleteBackend(name: string) {
+    return fetch(`${this.BASE_URL}/backend/${name}`, {
+      method: "DELETE",
+    });
+  }
 }